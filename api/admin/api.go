@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/database"
+)
+
+// API handles administrative endpoints that operate on the database itself.
+// Every request must carry the admin token as a bearer token.
+type API struct {
+	db        *database.DB
+	backupDir string
+	token     string
+}
+
+// NewAPI creates a new admin API instance that writes backups to backupDir
+// and accepts requests bearing token, which must not be empty
+func NewAPI(db *database.DB, backupDir, token string) *API {
+	return &API{
+		db:        db,
+		backupDir: backupDir,
+		token:     token,
+	}
+}
+
+// CreateRoutes registers all admin API routes
+func (a *API) CreateRoutes(routes *mux.Router) {
+	routes.Use(a.authorize)
+
+	// POST /api/admin/backup - write a consistent copy of the live database to the backup directory
+	routes.Path("/backup").HandlerFunc(a.PostBackup).Methods("POST")
+}
+
+// authorize rejects requests that do not bear the admin token
+func (a *API) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := "Bearer " + a.token
+		given := r.Header.Get("Authorization")
+		if a.token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			err := api.WriteError(w, "admin token required", http.StatusUnauthorized)
+			api.LogError(err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
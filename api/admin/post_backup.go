@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/database"
+)
+
+// PostBackup backs up the live database to a file in the backup directory,
+// named in the request body, without stopping the server
+//
+// The name must be a plain file name; paths are rejected so a client cannot
+// write outside the backup directory.
+func (a *API) PostBackup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		err := api.WriteError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+	if body.Name == "" {
+		err := api.WriteError(w, "invalid input; name is required", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	started := time.Now()
+	path, err := a.db.BackupNamed(ctx, a.backupDir, body.Name)
+	if err != nil {
+		if errors.Is(err, database.ErrBackupNameInvalid) {
+			err := api.WriteError(w, "invalid input; name must be a file name without path separators", http.StatusBadRequest)
+			api.LogError(err)
+			return
+		}
+		if errors.Is(err, database.ErrBackupExists) {
+			err := api.WriteError(w, "a backup with that name already exists", http.StatusConflict)
+			api.LogError(err)
+			return
+		}
+		errInWriting := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		api.LogError(errInWriting)
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		errInWriting := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		api.LogError(errInWriting)
+		return
+	}
+
+	response := struct {
+		Name      string    `json:"name"`
+		Path      string    `json:"path"`
+		Size      int64     `json:"size"`
+		CreatedAt time.Time `json:"created_at"`
+	}{
+		Name:      body.Name,
+		Path:      path,
+		Size:      info.Size(),
+		CreatedAt: started,
+	}
+
+	err = api.WriteJSON(w, response, http.StatusOK)
+	api.LogError(err)
+}
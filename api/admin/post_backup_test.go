@@ -0,0 +1,69 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api/admin"
+	"github.com/rainbowmga/timetravel/database"
+)
+
+func TestPostBackup(t *testing.T) {
+	dir := t.TempDir()
+	db, err := database.NewDB(filepath.Join(dir, "timetravel.db"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	defer db.Close()
+
+	backupDir := filepath.Join(dir, "backups")
+	router := mux.NewRouter()
+	admin.NewAPI(db, backupDir, "secret").CreateRoutes(router.PathPrefix("/api/admin").Subrouter())
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+	}{
+		{"NoToken", "", `{"name":"a.db"}`, http.StatusUnauthorized},
+		{"WrongToken", "wrong", `{"name":"a.db"}`, http.StatusUnauthorized},
+		{"Name", "secret", `{"name":"a.db"}`, http.StatusOK},
+		{"Exists", "secret", `{"name":"a.db"}`, http.StatusConflict},
+		{"Path", "secret", `{"name":"nested/a.db"}`, http.StatusBadRequest},
+		{"Parent", "secret", `{"name":"../a.db"}`, http.StatusBadRequest},
+		{"Dots", "secret", `{"name":".."}`, http.StatusBadRequest},
+		{"Absolute", "secret", `{"name":"/tmp/a.db"}`, http.StatusBadRequest},
+		{"Backslash", "secret", `{"name":"..\\a.db"}`, http.StatusBadRequest},
+		{"Missing", "secret", `{}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/admin/backup", strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "a.db" {
+		t.Errorf("backup directory holds %v, want only a.db", entries)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.db")); err == nil {
+		t.Errorf("a backup was written outside the backup directory")
+	}
+}
@@ -0,0 +1,55 @@
+package api_test
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/api/apitest"
+	"github.com/rainbowmga/timetravel/database"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// newHandler returns a handler serving the v1 routes from recordService
+func newHandler(recordService service.RecordService) http.Handler {
+	router := mux.NewRouter()
+	api.NewAPI(recordService).CreateRoutes(router.PathPrefix("/api/v1").Subrouter())
+	return router
+}
+
+// newTestDB returns a migrated database in a temporary directory, closed when
+// the test ends
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "timetravel.db"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestV1ContractVersioned serves v1 as the server does, writing through the
+// versioned SQLite service
+func TestV1ContractVersioned(t *testing.T) {
+	apitest.TestV1Contract(t, func(t *testing.T) http.Handler {
+		versioned := service.NewSQLiteVersionedRecordService(newTestDB(t))
+		return newHandler(service.NewSourceRecordService(versioned, "v1"))
+	})
+}
+
+// TestV1ContractSQLite serves v1 from the plain SQLite record service it was
+// served from before writes went through the versioned service
+func TestV1ContractSQLite(t *testing.T) {
+	apitest.TestV1Contract(t, func(t *testing.T) http.Handler {
+		return newHandler(service.NewSQLiteRecordService(newTestDB(t)))
+	})
+}
+
+func TestV1ContractInMemory(t *testing.T) {
+	apitest.TestV1Contract(t, func(t *testing.T) http.Handler {
+		return newHandler(service.NewInMemoryRecordService())
+	})
+}
@@ -0,0 +1,141 @@
+// Package apitest pins the HTTP contract of the API, so that changes to the
+// services behind it cannot change what clients see.
+//
+// A server wiring is expected to pass it from its own tests:
+//
+//	func TestV1Contract(t *testing.T) {
+//		apitest.TestV1Contract(t, func(t *testing.T) http.Handler {
+//			router := mux.NewRouter()
+//			api.NewAPI(service.NewInMemoryRecordService()).CreateRoutes(router.PathPrefix("/api/v1").Subrouter())
+//			return router
+//		})
+//	}
+//
+// The factory is called once per test and must return a handler serving the
+// v1 routes under /api/v1 from an empty store.
+package apitest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// HandlerFactory returns a handler serving the API from an empty store
+type HandlerFactory func(t *testing.T) http.Handler
+
+// contentType is sent with every response, errors included
+const contentType = "application/json; charset=utf-8"
+
+// exchange is a request and the exact response it must get
+type exchange struct {
+	name   string
+	method string
+	path   string
+	body   string
+	status int
+	want   string
+}
+
+// v1Contract is played in order against a single handler. Response bodies are
+// compared byte for byte; keys are always encoded in sorted order.
+var v1Contract = []exchange{
+	{
+		name: "get missing record", method: "GET", path: "/api/v1/records/1",
+		status: http.StatusBadRequest, want: `{"error":"record of id 1 does not exist"}` + "\n",
+	},
+	{
+		name: "get zero id", method: "GET", path: "/api/v1/records/0",
+		status: http.StatusBadRequest, want: `{"error":"invalid id; id must be a positive number"}` + "\n",
+	},
+	{
+		name: "get negative id", method: "GET", path: "/api/v1/records/-1",
+		status: http.StatusBadRequest, want: `{"error":"invalid id; id must be a positive number"}` + "\n",
+	},
+	{
+		name: "get non-numeric id", method: "GET", path: "/api/v1/records/abc",
+		status: http.StatusBadRequest, want: `{"error":"invalid id; id must be a positive number"}` + "\n",
+	},
+	{
+		name: "get id out of range", method: "GET", path: "/api/v1/records/2147483648",
+		status: http.StatusBadRequest, want: `{"error":"invalid id; id must be a positive number"}` + "\n",
+	},
+	{
+		name: "post zero id", method: "POST", path: "/api/v1/records/0", body: `{"a":"1"}`,
+		status: http.StatusBadRequest, want: `{"error":"invalid id; id must be a positive number"}` + "\n",
+	},
+	{
+		name: "post malformed json", method: "POST", path: "/api/v1/records/1", body: `{"a":`,
+		status: http.StatusBadRequest, want: `{"error":"invalid input; could not parse json"}` + "\n",
+	},
+	{
+		name: "post non-string value", method: "POST", path: "/api/v1/records/1", body: `{"a":1}`,
+		status: http.StatusBadRequest, want: `{"error":"invalid input; could not parse json"}` + "\n",
+	},
+	{
+		name: "create leaves out nulls", method: "POST", path: "/api/v1/records/1",
+		body:   `{"hello":"world","status":"active","gone":null}`,
+		status: http.StatusOK, want: `{"id":1,"data":{"hello":"world","status":"active"}}` + "\n",
+	},
+	{
+		name: "get created record", method: "GET", path: "/api/v1/records/1",
+		status: http.StatusOK, want: `{"id":1,"data":{"hello":"world","status":"active"}}` + "\n",
+	},
+	{
+		name: "update merges", method: "POST", path: "/api/v1/records/1",
+		body:   `{"hello":"world 2","newfield":"added"}`,
+		status: http.StatusOK, want: `{"id":1,"data":{"hello":"world 2","newfield":"added","status":"active"}}` + "\n",
+	},
+	{
+		name: "update deletes nulls", method: "POST", path: "/api/v1/records/1",
+		body:   `{"newfield":null,"missing":null}`,
+		status: http.StatusOK, want: `{"id":1,"data":{"hello":"world 2","status":"active"}}` + "\n",
+	},
+	{
+		name: "update with no changes", method: "POST", path: "/api/v1/records/1", body: `{}`,
+		status: http.StatusOK, want: `{"id":1,"data":{"hello":"world 2","status":"active"}}` + "\n",
+	},
+	{
+		name: "get updated record", method: "GET", path: "/api/v1/records/1",
+		status: http.StatusOK, want: `{"id":1,"data":{"hello":"world 2","status":"active"}}` + "\n",
+	},
+	{
+		name: "create empty record", method: "POST", path: "/api/v1/records/2", body: `{}`,
+		status: http.StatusOK, want: `{"id":2,"data":{}}` + "\n",
+	},
+	{
+		name: "create from null body", method: "POST", path: "/api/v1/records/3", body: `null`,
+		status: http.StatusOK, want: `{"id":3,"data":{}}` + "\n",
+	},
+	{
+		name: "get empty record", method: "GET", path: "/api/v1/records/2",
+		status: http.StatusOK, want: `{"id":2,"data":{}}` + "\n",
+	},
+	{
+		name: "html is escaped", method: "POST", path: "/api/v1/records/4",
+		body:   `{"html":"<b>&</b>","quote":"\"","unicode":"café"}`,
+		status: http.StatusOK, want: `{"id":4,"data":{"html":"\u003cb\u003e\u0026\u003c/b\u003e","quote":"\"","unicode":"café"}}` + "\n",
+	},
+}
+
+// TestV1Contract checks the exact responses of the v1 API
+func TestV1Contract(t *testing.T, newHandler HandlerFactory) {
+	handler := newHandler(t)
+
+	for _, e := range v1Contract {
+		r := httptest.NewRequest(e.method, e.path, strings.NewReader(e.body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != e.status {
+			t.Errorf("%s: %s %s: status = %d, want %d", e.name, e.method, e.path, w.Code, e.status)
+		}
+		if got := w.Header().Get("Content-Type"); got != contentType {
+			t.Errorf("%s: %s %s: Content-Type = %q, want %q", e.name, e.method, e.path, got, contentType)
+		}
+		if got := w.Body.String(); got != e.want {
+			t.Errorf("%s: %s %s: body = %s, want %s", e.name, e.method, e.path, got, e.want)
+		}
+	}
+}
@@ -19,7 +19,18 @@ func NewAPI(versionedService service.VersionedRecordService) *API {
 
 // CreateRoutes registers all v2 API routes
 func (a *API) CreateRoutes(routes *mux.Router) {
-	// GET /api/v2/records/{id} - get latest version
+	// Record who made each change, why and through which system
+	routes.Use(changeMetadataMiddleware)
+
+	// GET /api/v2/records?cursor=&limit=&updated_since=<RFC3339>&as_of=<RFC3339>&data.<key>[op]=<value> - list records in ID order, a page at a time
+	routes.Path("/records").HandlerFunc(a.ListRecords).Methods("GET")
+
+	// GET /api/v2/records/by/{key}/{value}?cursor=&limit=&updated_since=<RFC3339>&data.<key>[op]=<value> - list records holding value at an indexed key
+	// Registered before the routes of single records, which would otherwise
+	// match it for some keys
+	routes.Path("/records/by/{key}/{value}").HandlerFunc(a.GetRecordsByIndex).Methods("GET")
+
+	// GET /api/v2/records/{id} - get latest version, or the version current at ?as_of=<RFC3339>
 	routes.Path("/records/{id}").HandlerFunc(a.GetRecord).Methods("GET")
 
 	// GET /api/v2/records/{id}/versions - list all versions
@@ -28,6 +39,66 @@ func (a *API) CreateRoutes(routes *mux.Router) {
 	// GET /api/v2/records/{id}/versions/{version} - get specific version
 	routes.Path("/records/{id}/versions/{version}").HandlerFunc(a.GetRecordVersion).Methods("GET")
 
+	// GET /api/v2/records/{id}/versions/{version}/diff/{other} - compare two versions
+	routes.Path("/records/{id}/versions/{version}/diff/{other}").HandlerFunc(a.GetVersionDiff).Methods("GET")
+
+	// GET /api/v2/records/{id}/timeline - list versions with their validity intervals
+	routes.Path("/records/{id}/timeline").HandlerFunc(a.GetTimeline).Methods("GET")
+
+	// GET /api/v2/records/{id}/tags/{tag} - get the version a tag points at
+	routes.Path("/records/{id}/tags/{tag}").HandlerFunc(a.GetTag).Methods("GET")
+
+	// PUT /api/v2/records/{id}/tags/{tag} - tag a version
+	routes.Path("/records/{id}/tags/{tag}").HandlerFunc(a.PutTag).Methods("PUT")
+
+	// POST /api/v2/records/{id}/tags/{tag}/move - move an existing tag to another version
+	routes.Path("/records/{id}/tags/{tag}/move").HandlerFunc(a.PostMoveTag).Methods("POST")
+
+	// GET /api/v2/records/{id}/fields/{key}/history - list every change to a single key
+	routes.Path("/records/{id}/fields/{key}/history").HandlerFunc(a.GetFieldHistory).Methods("GET")
+
 	// POST /api/v2/records/{id} - create or update with versioning
 	routes.Path("/records/{id}").HandlerFunc(a.PostRecord).Methods("POST")
+
+	// DELETE /api/v2/records/{id} - soft-delete by appending a tombstone version
+	routes.Path("/records/{id}").HandlerFunc(a.DeleteRecord).Methods("DELETE")
+
+	// POST /api/v2/records/{id}/undelete - make the last live state of a deleted record current again
+	routes.Path("/records/{id}/undelete").HandlerFunc(a.PostUndelete).Methods("POST")
+
+	// POST /api/v2/records/{id}/versions/{version}/restore - make an earlier version current again
+	routes.Path("/records/{id}/versions/{version}/restore").HandlerFunc(a.PostRestore).Methods("POST")
+
+	// GET /api/v2/snapshot?as_of=<RFC3339> - stream every record as it was at a point in time
+	routes.Path("/snapshot").HandlerFunc(a.GetSnapshot).Methods("GET")
+
+	// POST /api/v2/records/{id}/corrections?effective_from=<RFC3339> - apply a change retroactively
+	routes.Path("/records/{id}/corrections").HandlerFunc(a.PostCorrection).Methods("POST")
+
+	// GET /api/v2/records/{id}/verify - check the hash chain of the record's versions
+	routes.Path("/records/{id}/verify").HandlerFunc(a.GetVerify).Methods("GET")
+
+	// GET /api/v2/records/{id}/retention - get the record's retention policy override and legal hold
+	routes.Path("/records/{id}/retention").HandlerFunc(a.GetRetention).Methods("GET")
+
+	// PUT /api/v2/records/{id}/retention - override the global retention policy for the record
+	routes.Path("/records/{id}/retention").HandlerFunc(a.PutRetention).Methods("PUT")
+
+	// DELETE /api/v2/records/{id}/retention - make the record follow the global retention policy
+	routes.Path("/records/{id}/retention").HandlerFunc(a.DeleteRetention).Methods("DELETE")
+
+	// PUT /api/v2/records/{id}/legal-hold - block all pruning of the record's history
+	routes.Path("/records/{id}/legal-hold").HandlerFunc(a.PutLegalHold).Methods("PUT")
+
+	// DELETE /api/v2/records/{id}/legal-hold - release the legal hold
+	routes.Path("/records/{id}/legal-hold").HandlerFunc(a.DeleteLegalHold).Methods("DELETE")
+
+	// GET /api/v2/indexes - list the indexed keys of record data
+	routes.Path("/indexes").HandlerFunc(a.GetIndexes).Methods("GET")
+
+	// PUT /api/v2/indexes/{key} - index a key of record data, backfilling its index
+	routes.Path("/indexes/{key}").HandlerFunc(a.PutIndex).Methods("PUT")
+
+	// DELETE /api/v2/indexes/{key} - stop indexing a key of record data
+	routes.Path("/indexes/{key}").HandlerFunc(a.DeleteIndex).Methods("DELETE")
 }
@@ -0,0 +1,72 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// DeleteRecord soft-deletes a record by appending a tombstone version. The
+// record's history stays readable, and reads of it as it was before the
+// deletion still return it.
+//
+// The deletion is effective from the time it is recorded, or from the RFC3339
+// timestamp in the effective_from query parameter.
+func (a *API) DeleteRecord(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	if effective := r.URL.Query().Get("effective_from"); effective != "" {
+		t, ok := parseTimeParam(w, "effective_from", effective, time.Time{})
+		if !ok {
+			return
+		}
+		ctx = service.WithEffectiveFrom(ctx, t)
+	}
+
+	err = a.versionedService.DeleteRecord(ctx, int(idNumber))
+	if err != nil {
+		writeDeletionError(w, err, int(idNumber))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeDeletionError responds with an error returned when deleting or
+// undeleting a record
+func writeDeletionError(w http.ResponseWriter, err error, id int) {
+	switch {
+	case errors.Is(err, service.ErrRecordDoesNotExist):
+		err := api.WriteError(w, fmt.Sprintf("record of id %v does not exist", id), http.StatusNotFound)
+		api.LogError(err)
+	case errors.Is(err, service.ErrRecordDeleted):
+		err := api.WriteError(w, fmt.Sprintf("record of id %v was deleted", id), http.StatusGone)
+		api.LogError(err)
+	case errors.Is(err, service.ErrRecordNotDeleted):
+		err := api.WriteError(w, fmt.Sprintf("record of id %v is not deleted", id), http.StatusConflict)
+		api.LogError(err)
+	case errors.Is(err, service.ErrEffectiveTimeInFuture):
+		err := api.WriteError(w, "invalid effective_from; effective_from must not be in the future", http.StatusBadRequest)
+		api.LogError(err)
+	case errors.Is(err, service.ErrEffectiveTimeConflict):
+		err := api.WriteError(w, "effective_from precedes the record's latest effective version", http.StatusConflict)
+		api.LogError(err)
+	default:
+		errInWriting := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		api.LogError(errInWriting)
+	}
+}
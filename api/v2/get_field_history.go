@@ -0,0 +1,45 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GetFieldHistory lists the versions in which a single key of a record was
+// added, changed or removed
+func (a *API) GetFieldHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	idNumber, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	history, err := a.versionedService.GetFieldHistory(ctx, int(idNumber), key)
+	if err != nil {
+		if err == service.ErrRecordDoesNotExist {
+			err := api.WriteError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusNotFound)
+			api.LogError(err)
+			return
+		}
+		err := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		return
+	}
+
+	err = api.WriteJSON(w, map[string]interface{}{
+		"id":      idNumber,
+		"key":     key,
+		"history": history,
+	}, http.StatusOK)
+	api.LogError(err)
+}
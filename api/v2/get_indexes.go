@@ -0,0 +1,25 @@
+package v2
+
+import (
+	"net/http"
+
+	"github.com/rainbowmga/timetravel/api"
+)
+
+// GetIndexes lists the indexed keys of record data, with how many records
+// each one indexes
+func (a *API) GetIndexes(w http.ResponseWriter, r *http.Request) {
+	indexes, ok := a.indexService(w)
+	if !ok {
+		return
+	}
+
+	list, err := indexes.ListIndexes(r.Context())
+	if err != nil {
+		writeIndexError(w, err, "")
+		return
+	}
+
+	err = api.WriteJSON(w, list, http.StatusOK)
+	api.LogError(err)
+}
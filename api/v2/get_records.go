@@ -4,13 +4,29 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/entity"
 	"github.com/rainbowmga/timetravel/service"
 )
 
 // GetRecord retrieves the latest version of a record (v2 API)
+//
+// If the as_of query parameter is set to an RFC3339 timestamp, the record is
+// returned as it was recorded at that instant instead.
+//
+// The valid_at and known_at query parameters make a bitemporal read: the record
+// as it was in effect at valid_at, according to what had been recorded by
+// known_at. Either one defaults to the time of the request.
+//
+// A record that was deleted at the time read is gone (410), unlike one that
+// did not exist yet (404). So is a read of history removed by retention.
+//
+// A read of the current state carries an ETag naming the record's latest
+// version, which can be passed in If-Match to update the record only if it
+// was not changed since.
 func (a *API) GetRecord(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	id := mux.Vars(r)["id"]
@@ -22,18 +38,62 @@ func (a *API) GetRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	record, err := a.versionedService.GetRecord(ctx, int(idNumber))
+	query := r.URL.Query()
+	asOf, validAt, knownAt := query.Get("as_of"), query.Get("valid_at"), query.Get("known_at")
+	if asOf != "" && (validAt != "" || knownAt != "") {
+		err := api.WriteError(w, "as_of cannot be combined with valid_at or known_at", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	var record entity.Record
+	var version int
+	switch {
+	case asOf != "":
+		t, ok := parseTimeParam(w, "as_of", asOf, time.Time{})
+		if !ok {
+			return
+		}
+		record, err = a.versionedService.GetRecordAsOf(ctx, int(idNumber), t)
+	case validAt != "" || knownAt != "":
+		now := time.Now()
+		validTime, ok := parseTimeParam(w, "valid_at", validAt, now)
+		if !ok {
+			return
+		}
+		knownTime, ok := parseTimeParam(w, "known_at", knownAt, now)
+		if !ok {
+			return
+		}
+		record, err = a.versionedService.GetRecordAt(ctx, int(idNumber), validTime, knownTime)
+	default:
+		record, version, err = a.versionedService.GetRecordWithVersion(ctx, int(idNumber))
+	}
+
 	if err != nil {
 		if err == service.ErrRecordDoesNotExist {
 			err := api.WriteError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusNotFound)
 			api.LogError(err)
 			return
 		}
+		if err == service.ErrVersionCompacted {
+			err := api.WriteError(w, fmt.Sprintf("history of record of id %v at that time was removed by retention", idNumber), http.StatusGone)
+			api.LogError(err)
+			return
+		}
+		if err == service.ErrRecordDeleted {
+			err := api.WriteError(w, fmt.Sprintf("record of id %v was deleted", idNumber), http.StatusGone)
+			api.LogError(err)
+			return
+		}
 		err := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
 		api.LogError(err)
 		return
 	}
 
+	if version > 0 {
+		w.Header().Set("ETag", etag(version))
+	}
 	err = api.WriteJSON(w, record, http.StatusOK)
 	api.LogError(err)
 }
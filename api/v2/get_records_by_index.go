@@ -0,0 +1,46 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GetRecordsByIndex returns a page of the records holding a value at an
+// indexed key of their data, in ID order (v2 API)
+//
+// The records are looked up in the key's index instead of being scanned, so
+// only their current state can be searched. The cursor, limit, updated_since
+// and data filter query parameters work as they do for ListRecords.
+func (a *API) GetRecordsByIndex(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, value := vars["key"], vars["value"]
+
+	q, ok := parseRecordQuery(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	indexes, ok := a.indexService(w)
+	if !ok {
+		return
+	}
+
+	page, err := indexes.ListRecordsByIndex(r.Context(), key, value, q)
+	if err != nil {
+		if errors.Is(err, service.ErrKeyNotIndexed) {
+			err := api.WriteError(w, fmt.Sprintf("key %q is not indexed; filter on it with GET /api/v2/records instead", key), http.StatusBadRequest)
+			api.LogError(err)
+			return
+		}
+		writeListError(w, err)
+		return
+	}
+
+	err = api.WriteJSON(w, page, http.StatusOK)
+	api.LogError(err)
+}
@@ -0,0 +1,60 @@
+package v2_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	v2 "github.com/rainbowmga/timetravel/api/v2"
+	"github.com/rainbowmga/timetravel/database"
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// TestGetRecordsByIndexRoute looks records up by keys named like the
+// segments of single-record routes, which must not take the request
+func TestGetRecordsByIndexRoute(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "timetravel.db"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	defer db.Close()
+
+	router := mux.NewRouter()
+	v2.NewAPI(service.NewSQLiteVersionedRecordService(db)).CreateRoutes(router.PathPrefix("/api/v2").Subrouter())
+	if rec := post(router, "/api/v2/records/1", "", `{"versions":"2","tags":"x"}`); rec.Code != http.StatusOK {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body)
+	}
+
+	for _, key := range []string{"versions", "tags"} {
+		req := httptest.NewRequest("PUT", "/api/v2/indexes/"+key, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("index %s: status %d: %s", key, rec.Code, rec.Body)
+		}
+	}
+
+	for _, path := range []string{
+		"/api/v2/records/by/versions/2",
+		"/api/v2/records/by/tags/x",
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d: %s", path, rec.Code, rec.Body)
+			continue
+		}
+		var page entity.RecordPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Errorf("GET %s: %v: %s", path, err, rec.Body)
+			continue
+		}
+		if len(page.Records) != 1 || page.Records[0].ID != 1 {
+			t.Errorf("GET %s = %+v, want record 1", path, page.Records)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package v2
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+)
+
+// GetRetention returns the retention policy override and legal hold of a record
+func (a *API) GetRetention(w http.ResponseWriter, r *http.Request) {
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	retention, ok := a.retentionService(w)
+	if !ok {
+		return
+	}
+
+	writeRetention(w, r, retention, int(idNumber))
+}
@@ -0,0 +1,84 @@
+package v2
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GetSnapshot streams every record as it was at the time given by the as_of
+// query parameter, or as it is now if as_of is not set
+func (a *API) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	asOf, ok := parseTimeParam(w, "as_of", r.URL.Query().Get("as_of"), time.Now())
+	if !ok {
+		return
+	}
+
+	// The response is written as records are read, so once the first record
+	// is out an error can only be logged; the JSON is left unterminated so
+	// clients can tell the snapshot is incomplete.
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	started := false
+	count := 0
+
+	err := a.versionedService.Snapshot(ctx, asOf, func(record entity.Record) error {
+		if !started {
+			w.Header().Add("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			asOfJSON, err := json.Marshal(asOf)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte(`{"as_of":` + string(asOfJSON) + `,"records":[`)); err != nil {
+				return err
+			}
+			started = true
+		} else if _, err := w.Write([]byte(",")); err != nil {
+			return err
+		}
+
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+
+		count++
+		if flusher != nil && count%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	if err != nil {
+		if !started && errors.Is(err, service.ErrVersionCompacted) {
+			err := api.WriteError(w, "history of records at that time was removed by retention", http.StatusGone)
+			api.LogError(err)
+			return
+		}
+		if !started {
+			errInWriting := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+			api.LogError(errInWriting)
+		}
+		api.LogError(err)
+		return
+	}
+
+	if !started {
+		err := api.WriteJSON(w, map[string]interface{}{
+			"as_of":   asOf,
+			"records": []entity.Record{},
+		}, http.StatusOK)
+		api.LogError(err)
+		return
+	}
+
+	_, err = w.Write([]byte("]}\n"))
+	api.LogError(err)
+}
@@ -0,0 +1,40 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GetTag retrieves a record at the version a tag points at
+func (a *API) GetTag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	tag := vars["tag"]
+
+	idNumber, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	record, err := a.versionedService.GetRecordByTag(ctx, int(idNumber), tag)
+	if err != nil {
+		if err == service.ErrTagDoesNotExist || err == service.ErrVersionDoesNotExist {
+			err := api.WriteError(w, fmt.Sprintf("tag %q of record %v does not exist", tag, idNumber), http.StatusNotFound)
+			api.LogError(err)
+			return
+		}
+		err := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		return
+	}
+
+	err = api.WriteJSON(w, record, http.StatusOK)
+	api.LogError(err)
+}
@@ -0,0 +1,50 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GetTimeline lists the versions of a record with the [valid_from, valid_to)
+// interval over which each was in effect. The optional known_at query
+// parameter returns the timeline as it had been recorded at that time.
+func (a *API) GetTimeline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	idNumber, err := strconv.ParseInt(id, 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	knownAt, ok := parseTimeParam(w, "known_at", r.URL.Query().Get("known_at"), time.Now())
+	if !ok {
+		return
+	}
+
+	entries, err := a.versionedService.GetTimeline(ctx, int(idNumber), knownAt)
+	if err != nil {
+		if err == service.ErrRecordDoesNotExist {
+			err := api.WriteError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusNotFound)
+			api.LogError(err)
+			return
+		}
+		err := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		return
+	}
+
+	err = api.WriteJSON(w, map[string]interface{}{
+		"id":       idNumber,
+		"timeline": entries,
+	}, http.StatusOK)
+	api.LogError(err)
+}
@@ -0,0 +1,49 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GetVerify checks the hash chain of a record's versions. A broken chain is
+// reported in the body of a successful response, with the first version whose
+// hash does not hold.
+func (a *API) GetVerify(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	integrity, ok := a.versionedService.(service.IntegrityService)
+	if !ok {
+		err := api.WriteError(w, "verification is not supported by this storage backend", http.StatusNotImplemented)
+		api.LogError(err)
+		return
+	}
+
+	result, err := integrity.Verify(ctx, int(idNumber))
+	if err != nil {
+		if errors.Is(err, service.ErrRecordDoesNotExist) {
+			err := api.WriteError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusNotFound)
+			api.LogError(err)
+			return
+		}
+		errInWriting := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		api.LogError(errInWriting)
+		return
+	}
+
+	err = api.WriteJSON(w, result, http.StatusOK)
+	api.LogError(err)
+}
@@ -7,10 +7,12 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/entity"
 	"github.com/rainbowmga/timetravel/service"
 )
 
-// GetRecordVersion retrieves a record at a specific version
+// GetRecordVersion retrieves a record at a specific version, together with
+// the version's metadata
 func (a *API) GetRecordVersion(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
@@ -43,6 +45,16 @@ func (a *API) GetRecordVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = api.WriteJSON(w, record, http.StatusOK)
+	info, err := a.versionedService.GetVersionInfo(ctx, int(idNumber), int(versionNumber))
+	if err != nil {
+		err := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		return
+	}
+
+	err = api.WriteJSON(w, struct {
+		entity.Record
+		entity.VersionInfo
+	}{record, info}, http.StatusOK)
 	api.LogError(err)
 }
@@ -0,0 +1,53 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// GetVersionDiff describes what changed in a record between two versions
+func (a *API) GetVersionDiff(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	idNumber, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	from, err := strconv.ParseInt(vars["version"], 10, 32)
+	if err != nil || from <= 0 {
+		err := api.WriteError(w, "invalid version; version must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	to, err := strconv.ParseInt(vars["other"], 10, 32)
+	if err != nil || to <= 0 {
+		err := api.WriteError(w, "invalid version; version must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	diff, err := a.versionedService.DiffVersions(ctx, int(idNumber), int(from), int(to))
+	if err != nil {
+		if err == service.ErrRecordDoesNotExist || err == service.ErrVersionDoesNotExist {
+			err := api.WriteError(w, fmt.Sprintf("record versions %v@%v and %v@%v do not both exist", idNumber, from, idNumber, to), http.StatusNotFound)
+			api.LogError(err)
+			return
+		}
+		err := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		return
+	}
+
+	err = api.WriteJSON(w, diff, http.StatusOK)
+	api.LogError(err)
+}
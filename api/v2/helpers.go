@@ -0,0 +1,160 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// Request headers carrying the metadata recorded with every version written
+const (
+	HeaderChangeAuthor = "X-Change-Author"
+	HeaderChangeReason = "X-Change-Reason"
+	HeaderChangeSource = "X-Change-Source"
+)
+
+// etag returns the entity tag of a record whose latest version is version.
+// Every write appends a version, so the tag changes whenever the record does.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseIfMatch returns the versions named by the entity tags of an If-Match
+// header, or service.AnyVersion for "*". Tags the API never returns, such as
+// weak tags, can match no version under the strong comparison If-Match uses
+// and are left out, so the precondition fails rather than being rejected. It
+// returns false if header is not a valid If-Match value.
+func parseIfMatch(header string) ([]int, bool) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return []int{service.AnyVersion}, true
+	}
+
+	versions := []int{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		weak := strings.HasPrefix(tag, "W/")
+		tag = strings.TrimPrefix(tag, "W/")
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' || strings.Contains(tag[1:len(tag)-1], `"`) {
+			return nil, false
+		}
+		version, err := strconv.Atoi(tag[1 : len(tag)-1])
+		if weak || err != nil || version <= 0 {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions, true
+}
+
+// changeMetadataMiddleware attaches the change metadata headers of a request
+// to its context, so versions written while handling it record them
+func changeMetadataMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadata := entity.ChangeMetadata{
+			Author: r.Header.Get(HeaderChangeAuthor),
+			Reason: r.Header.Get(HeaderChangeReason),
+			Source: r.Header.Get(HeaderChangeSource),
+		}
+		if metadata != (entity.ChangeMetadata{}) {
+			r = r.WithContext(service.WithChangeMetadata(r.Context(), metadata))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseTimeParam parses the RFC3339 timestamp given in the named query
+// parameter, returning fallback if it is empty. On a malformed value it writes
+// a bad request response and returns false.
+func parseTimeParam(w http.ResponseWriter, name string, value string, fallback time.Time) (time.Time, bool) {
+	if value == "" {
+		return fallback, true
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		err := api.WriteError(w, fmt.Sprintf("invalid %s; %s must be an RFC3339 timestamp", name, name), http.StatusBadRequest)
+		api.LogError(err)
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// retentionService returns the service managing retention, if the storage
+// backend supports it. Otherwise it writes a not implemented response and
+// returns false.
+func (a *API) retentionService(w http.ResponseWriter) (service.RetentionService, bool) {
+	retention, ok := a.versionedService.(service.RetentionService)
+	if !ok {
+		err := api.WriteError(w, "retention is not supported by this storage backend", http.StatusNotImplemented)
+		api.LogError(err)
+	}
+	return retention, ok
+}
+
+// writeRetention responds with the retention configuration of a record
+func writeRetention(w http.ResponseWriter, r *http.Request, retention service.RetentionService, id int) {
+	config, err := retention.GetRetention(r.Context(), id)
+	if err != nil {
+		writeRetentionError(w, err, id)
+		return
+	}
+
+	err = api.WriteJSON(w, config, http.StatusOK)
+	api.LogError(err)
+}
+
+// writeRetentionError responds with the error returned by a RetentionService
+func writeRetentionError(w http.ResponseWriter, err error, id int) {
+	switch {
+	case errors.Is(err, service.ErrRecordDoesNotExist):
+		err := api.WriteError(w, fmt.Sprintf("record of id %v does not exist", id), http.StatusNotFound)
+		api.LogError(err)
+	case errors.Is(err, service.ErrRetentionInvalid):
+		err := api.WriteError(w, "invalid retention; "+err.Error(), http.StatusBadRequest)
+		api.LogError(err)
+	default:
+		errInWriting := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		api.LogError(errInWriting)
+	}
+}
+
+// indexService returns the service managing indexes, if the storage backend
+// supports them. Otherwise it writes a not implemented response and returns
+// false.
+func (a *API) indexService(w http.ResponseWriter) (service.IndexService, bool) {
+	indexes, ok := a.versionedService.(service.IndexService)
+	if !ok {
+		err := api.WriteError(w, "indexes are not supported by this storage backend", http.StatusNotImplemented)
+		api.LogError(err)
+	}
+	return indexes, ok
+}
+
+// writeIndexError responds with the error returned by an IndexService
+func writeIndexError(w http.ResponseWriter, err error, key string) {
+	switch {
+	case errors.Is(err, service.ErrKeyNotIndexed):
+		err := api.WriteError(w, fmt.Sprintf("key %q is not indexed", key), http.StatusNotFound)
+		api.LogError(err)
+	case errors.Is(err, service.ErrIndexKeyInvalid):
+		err := api.WriteError(w, "invalid key; "+err.Error(), http.StatusBadRequest)
+		api.LogError(err)
+	default:
+		errInWriting := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		api.LogError(errInWriting)
+	}
+}
@@ -0,0 +1,156 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// ListRecords returns a page of records in ID order (v2 API)
+//
+// The limit query parameter sets the page size, up to service.MaxPageSize. A
+// page followed by another carries a next_cursor, which is passed back in
+// the cursor query parameter to get that page. If updated_since is set to an
+// RFC3339 timestamp, only records written at or after it are listed. Deleted
+// records are left out.
+//
+// Query parameters named after a key of the records' data filter on it:
+//
+//	data.<key>=<value>          the key holds value
+//	data.<key>[ne]=<value>      the key holds another value, or none
+//	data.<key>[prefix]=<value>  the key holds a value starting with value
+//	data.<key>[exists]=true     the key holds any value; false for none
+//
+// Records must match every filter. With as_of set to an RFC3339 timestamp the
+// records are listed, and filtered, as they were recorded at that time.
+func (a *API) ListRecords(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	q, ok := parseRecordQuery(w, query)
+	if !ok {
+		return
+	}
+
+	asOf, ok := parseTimeParam(w, "as_of", query.Get("as_of"), time.Time{})
+	if !ok {
+		return
+	}
+	q.AsOf = asOf
+
+	page, err := a.versionedService.ListRecords(ctx, q)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	err = api.WriteJSON(w, page, http.StatusOK)
+	api.LogError(err)
+}
+
+// parseRecordQuery returns the query selecting a page of records given in
+// the cursor, limit, updated_since and data filter query parameters. On a
+// malformed parameter it writes a bad request response and returns false.
+func parseRecordQuery(w http.ResponseWriter, query url.Values) (service.RecordQuery, bool) {
+	q := service.RecordQuery{Cursor: query.Get("cursor")}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > service.MaxPageSize {
+			err := api.WriteError(w, fmt.Sprintf("invalid limit; limit must be between 1 and %d", service.MaxPageSize), http.StatusBadRequest)
+			api.LogError(err)
+			return service.RecordQuery{}, false
+		}
+		q.Limit = n
+	}
+
+	updatedSince, ok := parseTimeParam(w, "updated_since", query.Get("updated_since"), time.Time{})
+	if !ok {
+		return service.RecordQuery{}, false
+	}
+	q.UpdatedSince = updatedSince
+
+	filters, err := parseFilters(query)
+	if err != nil {
+		err := api.WriteError(w, "invalid filter; "+err.Error(), http.StatusBadRequest)
+		api.LogError(err)
+		return service.RecordQuery{}, false
+	}
+	q.Filters = filters
+
+	return q, true
+}
+
+// writeListError responds with the error returned when listing a page of
+// records
+func writeListError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrFilterInvalid):
+		err := api.WriteError(w, "invalid filter; "+err.Error(), http.StatusBadRequest)
+		api.LogError(err)
+	case errors.Is(err, service.ErrCursorInvalid):
+		err := api.WriteError(w, "invalid cursor; pass the next_cursor of a previous page", http.StatusBadRequest)
+		api.LogError(err)
+	case errors.Is(err, service.ErrVersionCompacted):
+		err := api.WriteError(w, "history of records at that time was removed by retention", http.StatusGone)
+		api.LogError(err)
+	default:
+		errInWriting := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		api.LogError(errInWriting)
+	}
+}
+
+// filterPrefix starts the name of every query parameter filtering on data
+const filterPrefix = "data."
+
+// parseFilters returns the data filters given in the query parameters, in
+// parameter name order
+func parseFilters(query url.Values) ([]service.Filter, error) {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		if strings.HasPrefix(name, filterPrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var filters []service.Filter
+	for _, name := range names {
+		key, op := strings.TrimPrefix(name, filterPrefix), service.FilterEqual
+		if i := strings.LastIndex(key, "["); i >= 0 && strings.HasSuffix(key, "]") {
+			key, op = key[:i], key[i+1:len(key)-1]
+		}
+		if key == "" {
+			return nil, fmt.Errorf("%s names no key", name)
+		}
+
+		for _, value := range query[name] {
+			f := service.Filter{Key: key, Op: op, Value: value}
+			switch op {
+			case service.FilterEqual, service.FilterNotEqual, service.FilterPrefix:
+			case service.FilterExists:
+				exists, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s must be true or false", name)
+				}
+				if !exists {
+					f.Op = service.FilterMissing
+				}
+				f.Value = ""
+			default:
+				return nil, fmt.Errorf("unknown operator %q; use ne, prefix or exists", op)
+			}
+			filters = append(filters, f)
+		}
+	}
+	return filters, nil
+}
@@ -0,0 +1,74 @@
+package v2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// PostCorrection records a change that actually happened at the time given by
+// the required effective_from query parameter, and reports the intervals of
+// the record's timeline it changed
+func (a *API) PostCorrection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 32)
+
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	effective := r.URL.Query().Get("effective_from")
+	if effective == "" {
+		err := api.WriteError(w, "missing effective_from; a correction must say when the change happened", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+	effectiveFrom, ok := parseTimeParam(w, "effective_from", effective, time.Time{})
+	if !ok {
+		return
+	}
+
+	var body map[string]*string
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		err := api.WriteError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	report, err := a.versionedService.CorrectRecord(ctx, int(idNumber), effectiveFrom, body)
+	if err != nil {
+		if errors.Is(err, service.ErrRecordDoesNotExist) {
+			err := api.WriteError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusNotFound)
+			api.LogError(err)
+			return
+		}
+		if errors.Is(err, service.ErrRecordDeleted) {
+			err := api.WriteError(w, fmt.Sprintf("record of id %v was deleted", idNumber), http.StatusGone)
+			api.LogError(err)
+			return
+		}
+		if errors.Is(err, service.ErrEffectiveTimeInFuture) {
+			err := api.WriteError(w, "invalid effective_from; effective_from must not be in the future", http.StatusBadRequest)
+			api.LogError(err)
+			return
+		}
+		errInWriting := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		api.LogError(errInWriting)
+		return
+	}
+
+	err = api.WriteJSON(w, report, http.StatusOK)
+	api.LogError(err)
+}
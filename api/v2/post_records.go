@@ -3,8 +3,10 @@ package v2
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rainbowmga/timetravel/api"
@@ -12,7 +14,24 @@ import (
 	"github.com/rainbowmga/timetravel/service"
 )
 
+// expectedVersionField is the body field of a conditional update naming the
+// version it expects to replace
+const expectedVersionField = "expected_version"
+
 // PostRecord creates or updates a record with versioning (v2 API)
+//
+// The new version is effective from the time it is recorded, or from the
+// RFC3339 timestamp in the effective_from query parameter when the change
+// happened earlier than it was reported.
+//
+// An update is made conditional by passing the ETag of the version it was
+// based on in the If-Match header, or its number in the expected_version body
+// field. It then only applies if no other version was written since, and
+// otherwise fails with 412 Precondition Failed and the latest version.
+// If-Match may list several ETags, any of which matches, or be "*", which
+// matches any version of a record that exists. A conditional update never
+// creates a record. expected_version is never stored as record data; any
+// value but a positive integer is rejected.
 func (a *API) PostRecord(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	id := mux.Vars(r)["id"]
@@ -24,50 +43,140 @@ func (a *API) PostRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var body map[string]*string
-	err = json.NewDecoder(r.Body).Decode(&body)
+	if effective := r.URL.Query().Get("effective_from"); effective != "" {
+		t, ok := parseTimeParam(w, "effective_from", effective, time.Time{})
+		if !ok {
+			return
+		}
+		ctx = service.WithEffectiveFrom(ctx, t)
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.NewDecoder(r.Body).Decode(&fields)
 	if err != nil {
 		err := api.WriteError(w, "invalid input; could not parse json", http.StatusBadRequest)
 		api.LogError(err)
 		return
 	}
 
-	// Check if record exists
-	_, err = a.versionedService.GetRecord(ctx, int(idNumber))
-	recordExists := !errors.Is(err, service.ErrRecordDoesNotExist)
-
-	var record entity.Record
-	if recordExists {
-		// Update existing record
-		record, err = a.versionedService.UpdateRecord(ctx, int(idNumber), body)
-	} else {
-		// Create new record - exclude null values
-		recordMap := map[string]string{}
-		for key, value := range body {
-			if value != nil {
-				recordMap[key] = *value
-			}
-		}
+	expected, conditional, ok := expectedVersions(w, r, fields)
+	if !ok {
+		return
+	}
 
-		record = entity.Record{
-			ID:   int(idNumber),
-			Data: recordMap,
+	body := make(map[string]*string, len(fields))
+	for key, value := range fields {
+		var update *string
+		if err := json.Unmarshal(value, &update); err != nil {
+			err := api.WriteError(w, "invalid input; could not parse json", http.StatusBadRequest)
+			api.LogError(err)
+			return
 		}
-		err = a.versionedService.CreateRecord(ctx, record)
+		body[key] = update
 	}
 
+	var record entity.Record
+	var version int
+	if conditional {
+		record, version, err = a.versionedService.UpdateRecordIfVersion(ctx, int(idNumber), expected, body)
+	} else {
+		// Create or update the record atomically
+		record, version, err = a.versionedService.UpsertRecordWithVersion(ctx, int(idNumber), body)
+	}
 	if err != nil {
-		if err == service.ErrRecordAlreadyExists {
-			// This shouldn't happen, but handle it gracefully
+		var conflict *service.VersionConflictError
+		switch {
+		case errors.As(err, &conflict):
+			// A record written before versions were kept has no ETag yet
+			if conflict.Current > 0 {
+				w.Header().Set("ETag", etag(conflict.Current))
+			}
+			response := struct {
+				Error          string `json:"error"`
+				CurrentVersion int    `json:"current_version"`
+			}{
+				Error:          fmt.Sprintf("record of id %v is not at the expected version; its latest version is %d", idNumber, conflict.Current),
+				CurrentVersion: conflict.Current,
+			}
+			err := api.WriteJSON(w, response, http.StatusPreconditionFailed)
+			api.LogError(err)
+		case errors.Is(err, service.ErrRecordDoesNotExist) && anyVersion(expected):
+			// If-Match: * only holds for a record that exists
+			err := api.WriteError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusPreconditionFailed)
+			api.LogError(err)
+		case errors.Is(err, service.ErrRecordDoesNotExist):
+			err := api.WriteError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusNotFound)
+			api.LogError(err)
+		case errors.Is(err, service.ErrRecordDeleted):
+			err := api.WriteError(w, fmt.Sprintf("record of id %v was deleted", idNumber), http.StatusGone)
+			api.LogError(err)
+		case errors.Is(err, service.ErrEffectiveTimeInFuture):
+			err := api.WriteError(w, "invalid effective_from; effective_from must not be in the future", http.StatusBadRequest)
+			api.LogError(err)
+		case errors.Is(err, service.ErrEffectiveTimeConflict):
+			err := api.WriteError(w, "effective_from precedes the record's latest effective version", http.StatusConflict)
+			api.LogError(err)
+		default:
 			err := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
 			api.LogError(err)
-			return
 		}
-		err := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
-		api.LogError(err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(version))
 	err = api.WriteJSON(w, record, http.StatusOK)
 	api.LogError(err)
 }
+
+// expectedVersions returns the versions a conditional update expects to
+// replace, given in the If-Match header or the expected_version field of the
+// body, which it removes from fields, and whether the update is conditional
+// at all. On a malformed or conflicting precondition it writes a bad request
+// response and returns false.
+func expectedVersions(w http.ResponseWriter, r *http.Request, fields map[string]json.RawMessage) ([]int, bool, bool) {
+	var expected []int
+	conditional := false
+	if match := r.Header.Get("If-Match"); match != "" {
+		versions, ok := parseIfMatch(match)
+		if !ok {
+			err := api.WriteError(w, "invalid If-Match; If-Match must be \"*\" or a list of ETags", http.StatusBadRequest)
+			api.LogError(err)
+			return nil, false, false
+		}
+		expected, conditional = versions, true
+	}
+
+	value, ok := fields[expectedVersionField]
+	if !ok {
+		return expected, conditional, true
+	}
+	delete(fields, expectedVersionField)
+
+	var version int
+	if err := json.Unmarshal(value, &version); err != nil || version <= 0 || string(value) == "null" {
+		err := api.WriteError(w, "invalid expected_version; expected_version must be a positive integer", http.StatusBadRequest)
+		api.LogError(err)
+		return nil, false, false
+	}
+	if conditional && !anyVersion(expected) && !containsVersion(expected, version) {
+		err := api.WriteError(w, "If-Match and expected_version name different versions", http.StatusBadRequest)
+		api.LogError(err)
+		return nil, false, false
+	}
+	return []int{version}, true, true
+}
+
+// anyVersion reports whether versions is the precondition of If-Match: *
+func anyVersion(versions []int) bool {
+	return len(versions) == 1 && versions[0] == service.AnyVersion
+}
+
+// containsVersion reports whether version is one of versions
+func containsVersion(versions []int, version int) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package v2_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	v2 "github.com/rainbowmga/timetravel/api/v2"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// post sends a POST to path on handler with the given If-Match header, if any
+func post(handler http.Handler, path, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPostRecordIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		ifMatch string
+		status  int
+	}{
+		{"Current", "1", `"1"`, http.StatusOK},
+		{"Stale", "1", `"2"`, http.StatusPreconditionFailed},
+		{"ListMatches", "1", `"3", "1"`, http.StatusOK},
+		{"ListStale", "1", `"2", "3"`, http.StatusPreconditionFailed},
+		{"Any", "1", `*`, http.StatusOK},
+		{"AnyMissing", "2", `*`, http.StatusPreconditionFailed},
+		{"Weak", "1", `W/"1"`, http.StatusPreconditionFailed},
+		{"Foreign", "1", `"abc"`, http.StatusPreconditionFailed},
+		{"Unquoted", "1", `1`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := mux.NewRouter()
+			v2.NewAPI(service.NewInMemoryVersionedRecordService()).CreateRoutes(router.PathPrefix("/api/v2").Subrouter())
+			if rec := post(router, "/api/v2/records/1", "", `{"a":"1"}`); rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"1"` {
+				t.Fatalf("create: status %d, ETag %s: %s", rec.Code, rec.Header().Get("ETag"), rec.Body)
+			}
+
+			rec := post(router, "/api/v2/records/"+tt.id, tt.ifMatch, `{"a":"2"}`)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code == http.StatusOK && rec.Header().Get("ETag") != `"2"` {
+				t.Errorf("ETag = %s, want \"2\"", rec.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestPostRecordExpectedVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"Current", `{"a":"2","expected_version":1}`, http.StatusOK},
+		{"Stale", `{"a":"2","expected_version":2}`, http.StatusPreconditionFailed},
+		{"String", `{"a":"2","expected_version":"1"}`, http.StatusBadRequest},
+		{"Null", `{"a":"2","expected_version":null}`, http.StatusBadRequest},
+		{"Fraction", `{"a":"2","expected_version":1.5}`, http.StatusBadRequest},
+		{"Zero", `{"a":"2","expected_version":0}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			versioned := service.NewInMemoryVersionedRecordService()
+			router := mux.NewRouter()
+			v2.NewAPI(versioned).CreateRoutes(router.PathPrefix("/api/v2").Subrouter())
+			if rec := post(router, "/api/v2/records/1", "", `{"a":"1"}`); rec.Code != http.StatusOK {
+				t.Fatalf("create: status %d: %s", rec.Code, rec.Body)
+			}
+
+			rec := post(router, "/api/v2/records/1", "", tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			record, err := versioned.GetRecord(context.Background(), 1)
+			if err != nil {
+				t.Fatalf("GetRecord: %v", err)
+			}
+			if _, ok := record.Data["expected_version"]; ok {
+				t.Errorf("expected_version was stored as data: %v", record.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// PostRestore makes the data of an earlier version current again by appending
+// a new version
+//
+// The new version is effective from the time it is recorded, or from the
+// RFC3339 timestamp in the effective_from query parameter.
+func (a *API) PostRestore(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	idNumber, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	versionNumber, err := strconv.ParseInt(vars["version"], 10, 32)
+	if err != nil || versionNumber <= 0 {
+		err := api.WriteError(w, "invalid version; version must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	if effective := r.URL.Query().Get("effective_from"); effective != "" {
+		t, ok := parseTimeParam(w, "effective_from", effective, time.Time{})
+		if !ok {
+			return
+		}
+		ctx = service.WithEffectiveFrom(ctx, t)
+	}
+
+	record, err := a.versionedService.RestoreVersion(ctx, int(idNumber), int(versionNumber))
+	if err != nil {
+		if errors.Is(err, service.ErrRecordDoesNotExist) || errors.Is(err, service.ErrVersionDoesNotExist) {
+			err := api.WriteError(w, fmt.Sprintf("record version %v@%v does not exist", idNumber, versionNumber), http.StatusNotFound)
+			api.LogError(err)
+			return
+		}
+		if errors.Is(err, service.ErrRecordDeleted) {
+			err := api.WriteError(w, fmt.Sprintf("record of id %v was deleted; undelete it first", idNumber), http.StatusGone)
+			api.LogError(err)
+			return
+		}
+		if errors.Is(err, service.ErrVersionDeleted) {
+			err := api.WriteError(w, fmt.Sprintf("record version %v@%v is a tombstone and cannot be restored", idNumber, versionNumber), http.StatusBadRequest)
+			api.LogError(err)
+			return
+		}
+		if errors.Is(err, service.ErrEffectiveTimeInFuture) {
+			err := api.WriteError(w, "invalid effective_from; effective_from must not be in the future", http.StatusBadRequest)
+			api.LogError(err)
+			return
+		}
+		if errors.Is(err, service.ErrEffectiveTimeConflict) {
+			err := api.WriteError(w, "effective_from precedes the record's latest effective version", http.StatusConflict)
+			api.LogError(err)
+			return
+		}
+		errInWriting := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+		api.LogError(err)
+		api.LogError(errInWriting)
+		return
+	}
+
+	err = api.WriteJSON(w, record, http.StatusOK)
+	api.LogError(err)
+}
@@ -0,0 +1,44 @@
+package v2
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// PostUndelete makes the state a deleted record had when it was deleted
+// current again by appending a new version
+//
+// The new version is effective from the time it is recorded, or from the
+// RFC3339 timestamp in the effective_from query parameter.
+func (a *API) PostUndelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	if effective := r.URL.Query().Get("effective_from"); effective != "" {
+		t, ok := parseTimeParam(w, "effective_from", effective, time.Time{})
+		if !ok {
+			return
+		}
+		ctx = service.WithEffectiveFrom(ctx, t)
+	}
+
+	record, err := a.versionedService.UndeleteRecord(ctx, int(idNumber))
+	if err != nil {
+		writeDeletionError(w, err, int(idNumber))
+		return
+	}
+
+	err = api.WriteJSON(w, record, http.StatusOK)
+	api.LogError(err)
+}
@@ -0,0 +1,46 @@
+package v2
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+)
+
+// PutIndex indexes a key of record data, indexing every record that already
+// holds it before responding. Putting a key that is already indexed leaves
+// its index as it is.
+func (a *API) PutIndex(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	indexes, ok := a.indexService(w)
+	if !ok {
+		return
+	}
+
+	index, err := indexes.AddIndex(r.Context(), key)
+	if err != nil {
+		writeIndexError(w, err, key)
+		return
+	}
+
+	err = api.WriteJSON(w, index, http.StatusOK)
+	api.LogError(err)
+}
+
+// DeleteIndex stops indexing a key of record data
+func (a *API) DeleteIndex(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	indexes, ok := a.indexService(w)
+	if !ok {
+		return
+	}
+
+	if err := indexes.DropIndex(r.Context(), key); err != nil {
+		writeIndexError(w, err, key)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,42 @@
+package v2
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+)
+
+// PutLegalHold places a legal hold on a record, blocking all pruning of its
+// history until it is released
+func (a *API) PutLegalHold(w http.ResponseWriter, r *http.Request) {
+	a.setLegalHold(w, r, true)
+}
+
+// DeleteLegalHold releases the legal hold on a record
+func (a *API) DeleteLegalHold(w http.ResponseWriter, r *http.Request) {
+	a.setLegalHold(w, r, false)
+}
+
+// setLegalHold places or releases the legal hold on the record in the request
+func (a *API) setLegalHold(w http.ResponseWriter, r *http.Request, hold bool) {
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	retention, ok := a.retentionService(w)
+	if !ok {
+		return
+	}
+
+	if err := retention.SetLegalHold(r.Context(), int(idNumber), hold); err != nil {
+		writeRetentionError(w, err, int(idNumber))
+		return
+	}
+
+	writeRetention(w, r, retention, int(idNumber))
+}
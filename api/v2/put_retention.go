@@ -0,0 +1,66 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// PutRetention overrides the global retention policy for a record. The body
+// is {"keep_last": <versions>, "keep_days": <days>}; either may be left out
+// to set no limit of that kind.
+func (a *API) PutRetention(w http.ResponseWriter, r *http.Request) {
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	var policy entity.RetentionPolicy
+	err = json.NewDecoder(r.Body).Decode(&policy)
+	if err != nil {
+		err := api.WriteError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	retention, ok := a.retentionService(w)
+	if !ok {
+		return
+	}
+
+	if err := retention.SetRetention(r.Context(), int(idNumber), &policy); err != nil {
+		writeRetentionError(w, err, int(idNumber))
+		return
+	}
+
+	writeRetention(w, r, retention, int(idNumber))
+}
+
+// DeleteRetention removes a record's retention policy override, so it follows
+// the global policy again
+func (a *API) DeleteRetention(w http.ResponseWriter, r *http.Request) {
+	idNumber, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	retention, ok := a.retentionService(w)
+	if !ok {
+		return
+	}
+
+	if err := retention.SetRetention(r.Context(), int(idNumber), nil); err != nil {
+		writeRetentionError(w, err, int(idNumber))
+		return
+	}
+
+	writeRetention(w, r, retention, int(idNumber))
+}
@@ -0,0 +1,91 @@
+package v2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// PutTag names a version of a record. The body is {"version": <number>}.
+// A tag that already points at a different version is left in place and a
+// conflict is returned; use PostMoveTag to move it.
+func (a *API) PutTag(w http.ResponseWriter, r *http.Request) {
+	a.writeTag(w, r, false)
+}
+
+// PostMoveTag points an existing tag at a different version. The body is
+// {"version": <number>}.
+func (a *API) PostMoveTag(w http.ResponseWriter, r *http.Request) {
+	a.writeTag(w, r, true)
+}
+
+// writeTag creates or moves the tag named in the request
+func (a *API) writeTag(w http.ResponseWriter, r *http.Request, move bool) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	tag := vars["tag"]
+
+	idNumber, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil || idNumber <= 0 {
+		err := api.WriteError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	var body struct {
+		Version int `json:"version"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		err := api.WriteError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+	if body.Version <= 0 {
+		err := api.WriteError(w, "invalid version; version must be a positive number", http.StatusBadRequest)
+		api.LogError(err)
+		return
+	}
+
+	if move {
+		err = a.versionedService.MoveTag(ctx, int(idNumber), body.Version, tag)
+	} else {
+		err = a.versionedService.TagVersion(ctx, int(idNumber), body.Version, tag)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTagInvalid):
+			err := api.WriteError(w, "invalid tag; "+err.Error(), http.StatusBadRequest)
+			api.LogError(err)
+		case errors.Is(err, service.ErrVersionDoesNotExist):
+			err := api.WriteError(w, fmt.Sprintf("record version %v@%v does not exist", idNumber, body.Version), http.StatusNotFound)
+			api.LogError(err)
+		case errors.Is(err, service.ErrTagDoesNotExist):
+			err := api.WriteError(w, fmt.Sprintf("tag %q of record %v does not exist", tag, idNumber), http.StatusNotFound)
+			api.LogError(err)
+		case errors.Is(err, service.ErrTagAlreadyExists):
+			err := api.WriteError(w, fmt.Sprintf("tag %q of record %v already points at another version; move it explicitly", tag, idNumber), http.StatusConflict)
+			api.LogError(err)
+		default:
+			errInWriting := api.WriteError(w, api.ErrInternal.Error(), http.StatusInternalServerError)
+			api.LogError(err)
+			api.LogError(errInWriting)
+		}
+		return
+	}
+
+	err = api.WriteJSON(w, entity.Tag{
+		ID:      int(idNumber),
+		Tag:     tag,
+		Version: body.Version,
+	}, http.StatusOK)
+	api.LogError(err)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rainbowmga/timetravel/database"
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/segmentlog"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// listMigrations prints the schema version of the database and the
+// migrations that would be applied on the next start
+func listMigrations(dbPath string) error {
+	db, err := database.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema version: %d\n", current)
+
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Println("no pending migrations")
+		return nil
+	}
+
+	fmt.Println("pending migrations:")
+	for _, m := range pending {
+		fmt.Printf("  %d  %s\n", m.Version, m.Name)
+	}
+	return nil
+}
+
+// backup writes a consistent copy of the database to target. The database may
+// be in use by a running server.
+func backup(dbPath, target string) error {
+	// Open would create an empty database if there were none
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db, err := database.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Backup(context.Background(), target); err != nil {
+		return err
+	}
+	fmt.Printf("backed up %s to %s\n", dbPath, target)
+	return nil
+}
+
+// restore replaces the database with the backup at source
+func restore(dbPath, source string) error {
+	if err := database.Restore(source, dbPath); err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from %s; the previous database was kept as %s.before-restore\n", dbPath, source, dbPath)
+	return nil
+}
+
+// compact prunes the versions no longer kept by retention from every record
+func compact(dbPath string, global entity.RetentionPolicy) error {
+	db, err := database.NewDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	pruned, err := service.NewSQLiteVersionedRecordService(db).Compact(context.Background(), global)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("pruned %d versions\n", pruned)
+	return nil
+}
+
+// verify checks the hash chain of every record and prints each broken one.
+// It fails if any chain is broken.
+func verify(dbPath string) error {
+	db, err := database.NewDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	records, broken, unsealed := 0, 0, 0
+	err = service.NewSQLiteVersionedRecordService(db).VerifyAll(context.Background(), func(v entity.Verification) error {
+		records++
+		unsealed += v.Unsealed
+		if !v.Valid {
+			broken++
+			fmt.Printf("record %d: version %d: %s\n", v.ID, v.Broken.Version, v.Broken.Reason)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("verified %d records, %d broken", records, broken)
+	if unsealed > 0 {
+		fmt.Printf(", %d versions written before hashing could not be verified", unsealed)
+	}
+	fmt.Println()
+
+	if broken > 0 {
+		return fmt.Errorf("%d records failed verification", broken)
+	}
+	return nil
+}
+
+// rebuild replays the change-event log into the records projection
+func rebuild(dbPath string) error {
+	db, err := database.NewDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	records, repaired, imported, err := service.NewSQLiteVersionedRecordService(db).RebuildProjections(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rebuilt %d records from the event log, %d repaired, %d imported\n", records, repaired, imported)
+	return nil
+}
+
+// compactLog replaces the segment log of the file storage backend with a
+// snapshot of every record
+func compactLog(dataDir string) error {
+	storage, err := service.NewFileVersionedRecordService(dataDir, segmentlog.Options{})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if err := storage.CompactLog(); err != nil {
+		return err
+	}
+	fmt.Printf("compacted the segment log in %s\n", dataDir)
+	return nil
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrBackupExists      = errors.New("backup target already exists")
+	ErrInvalidBackup     = errors.New("backup is not a valid timetravel database")
+	ErrBackupNameInvalid = errors.New("backup name must be a file name without path separators")
+)
+
+// requiredTables must exist in any database this binary can migrate
+var requiredTables = []string{"records", "record_versions"}
+
+// Backup writes a consistent copy of the live database to path with VACUUM
+// INTO. Writers are not blocked while the copy is made, and the copy is
+// compacted. path must not exist yet.
+func (db *DB) Backup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%w: %s", ErrBackupExists, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to check backup target: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create backup directory: %w", err)
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// BackupNamed writes a consistent copy of the live database to a file called
+// name in dir, creating dir if needed, and returns the file's path. name must
+// be a plain file name, so a backup can never be written outside dir.
+func (db *DB) BackupNamed(ctx context.Context, dir, name string) (string, error) {
+	if !validBackupName(name) {
+		return "", ErrBackupNameInvalid
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := db.Backup(ctx, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// validBackupName reports whether name is a file name that stays within the
+// directory it is joined to
+func validBackupName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\\x00") && filepath.Base(name) == name
+}
+
+// Restore replaces the database at dbPath with the backup at backupPath. The
+// backup is copied next to dbPath, checked for integrity and migrated to the
+// current schema before it is swapped in, so a bad backup leaves the database
+// untouched. The replaced database is kept with a .before-restore suffix, and
+// is moved back if the restored one cannot be swapped in.
+//
+// The server must not be running against dbPath while it is restored.
+func Restore(backupPath, dbPath string) error {
+	if _, err := os.Stat(backupPath); err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+
+	staged := dbPath + ".restore"
+	removeDatabaseFiles(staged)
+	if err := copyFile(backupPath, staged); err != nil {
+		return err
+	}
+
+	if err := prepareRestore(staged); err != nil {
+		removeDatabaseFiles(staged)
+		return err
+	}
+
+	// Keep the database being replaced, including its write-ahead log
+	previous := dbPath + ".before-restore"
+	removeDatabaseFiles(previous)
+	var moved []string
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := os.Rename(dbPath+suffix, previous+suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			removeDatabaseFiles(staged)
+			return moveBack(previous, dbPath, moved, fmt.Errorf("failed to move aside current database: %w", err))
+		}
+		moved = append(moved, suffix)
+	}
+
+	if err := os.Rename(staged, dbPath); err != nil {
+		removeDatabaseFiles(staged)
+		return moveBack(previous, dbPath, moved, fmt.Errorf("failed to swap in restored database: %w", err))
+	}
+	return nil
+}
+
+// moveBack returns the files of the database moved aside to previous to
+// dbPath after a restore failed with cause, and returns cause, or an error
+// saying where the database was left if it could not be moved back
+func moveBack(previous, dbPath string, suffixes []string, cause error) error {
+	for _, suffix := range suffixes {
+		if err := os.Rename(previous+suffix, dbPath+suffix); err != nil {
+			return fmt.Errorf("%v; the current database was left at %s: %w", cause, previous, err)
+		}
+	}
+	return cause
+}
+
+// prepareRestore validates a staged backup and migrates it to the current schema
+func prepareRestore(path string) error {
+	db, err := Open(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer db.Close()
+
+	if err := db.Validate(); err != nil {
+		return err
+	}
+
+	if err := db.Migrate(); err != nil {
+		return fmt.Errorf("failed to migrate backup: %w", err)
+	}
+	return db.Close()
+}
+
+// Validate checks that the database is intact, holds the tables of a
+// timetravel database and is not newer than this binary
+func (db *DB) Validate() error {
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: integrity check failed: %s", ErrInvalidBackup, result)
+	}
+
+	for _, table := range requiredTables {
+		var exists bool
+		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", table).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to inspect schema: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("%w: missing table %s", ErrInvalidBackup, table)
+		}
+	}
+
+	// Fails with ErrSchemaTooNew if the backup came from a newer binary
+	if _, err := db.PendingMigrations(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// copyFile copies src to a new file dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to stage backup: %w", err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to stage backup: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to stage backup: %w", err)
+	}
+	return out.Close()
+}
+
+// removeDatabaseFiles removes a database file and its WAL and shared-memory files
+func removeDatabaseFiles(path string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+}
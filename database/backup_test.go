@@ -0,0 +1,188 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newDatabase creates a migrated database at path holding the given records,
+// and closes it
+func newDatabase(t *testing.T, path string, ids ...int) {
+	t.Helper()
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	defer db.Close()
+	for _, id := range ids {
+		if _, err := db.Exec("INSERT INTO records (id, data) VALUES (?, '{}')", id); err != nil {
+			t.Fatalf("insert record: %v", err)
+		}
+	}
+}
+
+// wantRecords checks that the database at path holds records with exactly
+// the given IDs
+func wantRecords(t *testing.T, path string, ids ...int) {
+	t.Helper()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id FROM records ORDER BY id")
+	if err != nil {
+		t.Fatalf("query records: %v", err)
+	}
+	defer rows.Close()
+	var got []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("scan record: %v", err)
+		}
+		got = append(got, id)
+	}
+	if len(got) != len(ids) {
+		t.Fatalf("%s holds records %v, want %v", filepath.Base(path), got, ids)
+	}
+	for i := range ids {
+		if got[i] != ids[i] {
+			t.Fatalf("%s holds records %v, want %v", filepath.Base(path), got, ids)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "timetravel.db")
+	backupPath := filepath.Join(dir, "backups", "backup.db")
+
+	newDatabase(t, dbPath, 1)
+	db, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := db.Backup(context.Background(), backupPath); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if err := db.Backup(context.Background(), backupPath); !errors.Is(err, ErrBackupExists) {
+		t.Errorf("Backup over an existing file = %v, want ErrBackupExists", err)
+	}
+	if _, err := db.Exec("INSERT INTO records (id, data) VALUES (2, '{}')"); err != nil {
+		t.Fatalf("insert record: %v", err)
+	}
+	db.Close()
+
+	if err := Restore(backupPath, dbPath); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	wantRecords(t, dbPath, 1)
+	wantRecords(t, dbPath+".before-restore", 1, 2)
+	if _, err := os.Stat(dbPath + ".restore"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("staged backup was left behind: %v", err)
+	}
+}
+
+// TestRestoreInvalid restores backups that Validate rejects and checks that
+// the database is left untouched
+func TestRestoreInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		create func(t *testing.T, path string)
+		want   error
+	}{
+		{"NotADatabase", func(t *testing.T, path string) {
+			if err := os.WriteFile(path, []byte("not a database, but long enough to look like one"), 0644); err != nil {
+				t.Fatalf("write backup: %v", err)
+			}
+		}, ErrInvalidBackup},
+		{"SchemaTooNew", func(t *testing.T, path string) {
+			newDatabase(t, path)
+			db, err := Open(path)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer db.Close()
+			_, err = db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, 'from the future', CURRENT_TIMESTAMP)", len(migrations)+1)
+			if err != nil {
+				t.Fatalf("record migration: %v", err)
+			}
+		}, ErrSchemaTooNew},
+		{"MissingTables", func(t *testing.T, path string) {
+			db, err := Open(path)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer db.Close()
+			if _, err := db.Exec("CREATE TABLE records (id INTEGER PRIMARY KEY, data TEXT)"); err != nil {
+				t.Fatalf("create table: %v", err)
+			}
+		}, ErrInvalidBackup},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dbPath := filepath.Join(dir, "timetravel.db")
+			backupPath := filepath.Join(dir, "backup.db")
+			newDatabase(t, dbPath, 1)
+			tt.create(t, backupPath)
+
+			// A file that is not a database may already fail to open
+			if db, err := Open(backupPath); err == nil {
+				err = db.Validate()
+				db.Close()
+				if !errors.Is(err, tt.want) {
+					t.Errorf("Validate = %v, want %v", err, tt.want)
+				}
+			}
+
+			if err := Restore(backupPath, dbPath); !errors.Is(err, tt.want) {
+				t.Errorf("Restore = %v, want %v", err, tt.want)
+			}
+			wantRecords(t, dbPath, 1)
+			if _, err := os.Stat(dbPath + ".restore"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("staged backup was left behind: %v", err)
+			}
+		})
+	}
+}
+
+// TestRestoreMoveBack fails a restore after the database was partly moved
+// aside, and checks that it is moved back
+func TestRestoreMoveBack(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "timetravel.db")
+	backupPath := filepath.Join(dir, "backup.db")
+	newDatabase(t, backupPath, 1)
+	newDatabase(t, dbPath, 2)
+	wal := []byte("write-ahead log")
+	if err := os.WriteFile(dbPath+"-wal", wal, 0644); err != nil {
+		t.Fatalf("write WAL: %v", err)
+	}
+
+	// The WAL cannot be moved over a directory that is not empty
+	blocker := dbPath + ".before-restore-wal"
+	if err := os.MkdirAll(filepath.Join(blocker, "file"), 0755); err != nil {
+		t.Fatalf("create blocker: %v", err)
+	}
+	if err := Restore(backupPath, dbPath); err == nil {
+		t.Fatalf("Restore moved the WAL over a directory")
+	}
+
+	if got, err := os.ReadFile(dbPath + "-wal"); err != nil || !bytes.Equal(got, wal) {
+		t.Errorf("WAL after a failed restore = %q, %v; want it untouched", got, err)
+	}
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatalf("remove blocker: %v", err)
+	}
+	if err := os.Remove(dbPath + "-wal"); err != nil {
+		t.Fatalf("remove WAL: %v", err)
+	}
+	wantRecords(t, dbPath, 2)
+}
@@ -13,13 +13,37 @@ const (
 	DefaultDBPath = "timetravel.db"
 )
 
+// dsnParams configures every connection:
+//   - foreign keys are enforced
+//   - the WAL journal lets readers proceed while a write is in progress
+//   - writers wait up to 5s for a lock instead of failing with SQLITE_BUSY
+//   - transactions start with BEGIN IMMEDIATE, so a read-modify-write
+//     transaction holds the write lock from its first read
+const dsnParams = "?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
 // DB wraps the sql.DB connection
 type DB struct {
 	*sql.DB
 }
 
-// NewDB creates a new database connection and initializes the schema
+// NewDB creates a new database connection and applies any pending migrations.
+// It fails with ErrSchemaTooNew if the database was migrated by a newer binary.
 func NewDB(dbPath string) (*DB, error) {
+	database, err := Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.Migrate(); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	return database, nil
+}
+
+// Open creates a new database connection without touching the schema
+func Open(dbPath string) (*DB, error) {
 	// Ensure the directory exists
 	dir := filepath.Dir(dbPath)
 	if dir != "." && dir != "" {
@@ -28,71 +52,19 @@ func NewDB(dbPath string) (*DB, error) {
 		}
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	// Connection settings are passed in the DSN so that every connection in
+	// the pool gets them, not just the first one
+	db, err := sql.Open("sqlite3", dbPath+dsnParams)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	// Enable foreign keys
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
-	}
-
-	database := &DB{DB: db}
-
-	if err := database.initSchema(); err != nil {
+	if err := db.Ping(); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
-	}
-
-	return database, nil
-}
-
-// initSchema creates the necessary tables for records and versions
-func (db *DB) initSchema() error {
-	// Records table stores the current state of each record
-	createRecordsTable := `
-	CREATE TABLE IF NOT EXISTS records (
-		id INTEGER PRIMARY KEY CHECK(id > 0),
-		data TEXT NOT NULL,
-		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-
-	// Record versions table stores historical versions of records
-	createVersionsTable := `
-	CREATE TABLE IF NOT EXISTS record_versions (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		record_id INTEGER NOT NULL,
-		version INTEGER NOT NULL,
-		data TEXT NOT NULL,
-		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (record_id) REFERENCES records(id) ON DELETE CASCADE,
-		UNIQUE(record_id, version)
-	);
-	`
-
-	// Index for faster lookups
-	createIndexes := `
-	CREATE INDEX IF NOT EXISTS idx_record_versions_record_id ON record_versions(record_id);
-	CREATE INDEX IF NOT EXISTS idx_record_versions_record_id_version ON record_versions(record_id, version);
-	`
-
-	if _, err := db.Exec(createRecordsTable); err != nil {
-		return fmt.Errorf("failed to create records table: %w", err)
-	}
-
-	if _, err := db.Exec(createVersionsTable); err != nil {
-		return fmt.Errorf("failed to create record_versions table: %w", err)
-	}
-
-	if _, err := db.Exec(createIndexes); err != nil {
-		return fmt.Errorf("failed to create indexes: %w", err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	return nil
+	return &DB{DB: db}, nil
 }
 
 // Close closes the database connection
@@ -0,0 +1,404 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+// Migration is one versioned change to the database schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+// migrations are applied in order, each in its own transaction. Never edit or
+// reorder a migration once released; append a new one instead.
+//
+// Databases created before migrations were tracked may already have some of
+// the columns added here, so columns are added only if they are missing.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create records and record_versions",
+		Up: execStatements(
+			// Records table stores the current state of each record
+			`CREATE TABLE IF NOT EXISTS records (
+				id INTEGER PRIMARY KEY CHECK(id > 0),
+				data TEXT NOT NULL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			// Record versions table stores historical versions of records
+			`CREATE TABLE IF NOT EXISTS record_versions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				record_id INTEGER NOT NULL,
+				version INTEGER NOT NULL,
+				data TEXT NOT NULL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (record_id) REFERENCES records(id) ON DELETE CASCADE,
+				UNIQUE(record_id, version)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_record_versions_record_id ON record_versions(record_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_record_versions_record_id_version ON record_versions(record_id, version)`,
+		),
+	},
+	{
+		// created_at is when a version was recorded (transaction time) and
+		// effective_from is when its data became true (valid time)
+		Version: 2,
+		Name:    "add effective_from to record_versions",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "record_versions", "effective_from", "DATETIME"); err != nil {
+				return err
+			}
+			_, err := tx.Exec("UPDATE record_versions SET effective_from = created_at WHERE effective_from IS NULL")
+			return err
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add restored_from to record_versions",
+		Up: func(tx *sql.Tx) error {
+			return addColumn(tx, "record_versions", "restored_from", "INTEGER")
+		},
+	},
+	{
+		// Record tags table maps names unique per record to one of its versions
+		Version: 4,
+		Name:    "create record_tags",
+		Up: execStatements(
+			`CREATE TABLE IF NOT EXISTS record_tags (
+				record_id INTEGER NOT NULL,
+				tag TEXT NOT NULL,
+				version INTEGER NOT NULL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (record_id, tag),
+				FOREIGN KEY (record_id, version) REFERENCES record_versions(record_id, version) ON DELETE CASCADE
+			)`,
+		),
+	},
+	{
+		Version: 5,
+		Name:    "add author, reason and source to record_versions",
+		Up: func(tx *sql.Tx) error {
+			for _, column := range []string{"author", "reason", "source"} {
+				if err := addColumn(tx, "record_versions", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Keyframes store the full data of a version, other versions store a
+		// delta against the version before them
+		Version: 6,
+		Name:    "add keyframe to record_versions",
+		Up: func(tx *sql.Tx) error {
+			return addColumn(tx, "record_versions", "keyframe", "BOOLEAN NOT NULL DEFAULT 1")
+		},
+	},
+	{
+		// Record retention holds per-record overrides of the global retention
+		// policy, where NULL limits follow the global policy, and legal holds.
+		// Record compactions marks the oldest versions of a record that were
+		// removed by retention.
+		Version: 7,
+		Name:    "create record_retention and record_compactions",
+		Up: execStatements(
+			`CREATE TABLE IF NOT EXISTS record_retention (
+				record_id INTEGER PRIMARY KEY,
+				keep_last INTEGER,
+				keep_days INTEGER,
+				legal_hold BOOLEAN NOT NULL DEFAULT 0,
+				updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (record_id) REFERENCES records(id) ON DELETE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS record_compactions (
+				record_id INTEGER PRIMARY KEY,
+				from_version INTEGER NOT NULL,
+				to_version INTEGER NOT NULL,
+				last_created_at DATETIME NOT NULL,
+				last_effective_from DATETIME NOT NULL,
+				compacted_at DATETIME NOT NULL,
+				FOREIGN KEY (record_id) REFERENCES records(id) ON DELETE CASCADE
+			)`,
+		),
+	},
+	{
+		// Each version stores a hash of its contents and the hash of the
+		// version before it. Versions written before this migration keep an
+		// empty hash. A compaction marker keeps the hash of the last version
+		// it removed so the chain can still be followed.
+		Version: 8,
+		Name:    "add hash chain to record_versions",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "record_versions", "hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+			return addColumn(tx, "record_compactions", "last_hash", "TEXT NOT NULL DEFAULT ''")
+		},
+	},
+	{
+		// Record events is the append-only change-event log and the source of
+		// truth for the current state of records, which becomes a projection
+		// of it. Each event carries the state of its record after the change.
+		// Existing records are imported with their current state.
+		Version: 9,
+		Name:    "create record_events",
+		Up: execStatements(
+			`CREATE TABLE IF NOT EXISTS record_events (
+				seq INTEGER PRIMARY KEY AUTOINCREMENT,
+				record_id INTEGER NOT NULL CHECK(record_id > 0),
+				type TEXT NOT NULL,
+				data TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				author TEXT NOT NULL DEFAULT '',
+				reason TEXT NOT NULL DEFAULT '',
+				source TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_record_events_record_id ON record_events(record_id)`,
+			`INSERT INTO record_events (record_id, type, data, created_at)
+				SELECT id, 'imported', data, updated_at FROM records ORDER BY id`,
+		),
+	},
+	{
+		// A deleted record keeps its row and history: a tombstone version
+		// marks the deletion, and deleted_at is set on the record until it is
+		// written again or undeleted
+		Version: 10,
+		Name:    "add soft delete",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "record_versions", "deleted", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+			return addColumn(tx, "records", "deleted_at", "DATETIME")
+		},
+	},
+	{
+		// Record indexes lists the keys of record data that are indexed.
+		// Record index entries maps each value held at an indexed key to the
+		// records that are not deleted holding it, and is kept in step with
+		// the records projection.
+		Version: 11,
+		Name:    "create record_indexes and record_index_entries",
+		Up: execStatements(
+			`CREATE TABLE IF NOT EXISTS record_indexes (
+				key TEXT PRIMARY KEY,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS record_index_entries (
+				key TEXT NOT NULL,
+				value TEXT NOT NULL,
+				record_id INTEGER NOT NULL,
+				PRIMARY KEY (key, value, record_id),
+				FOREIGN KEY (key) REFERENCES record_indexes(key) ON DELETE CASCADE,
+				FOREIGN KEY (record_id) REFERENCES records(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_record_index_entries_record_id ON record_index_entries(record_id)`,
+		),
+	},
+	{
+		// Anchor each record's hash chain on the record: sealed_from is its
+		// first version written since versions were hashed, so none after it
+		// may be unsealed, and head_version and head_hash are its latest
+		// version, so versions removed from the end are noticed. Records with
+		// no sealed version yet are sealed from their next version.
+		Version: 12,
+		Name:    "anchor hash chains on records",
+		Up: func(tx *sql.Tx) error {
+			for _, column := range []struct{ name, definition string }{
+				{"sealed_from", "INTEGER"},
+				{"head_version", "INTEGER"},
+				{"head_hash", "TEXT"},
+			} {
+				if err := addColumn(tx, "records", column.name, column.definition); err != nil {
+					return err
+				}
+			}
+			return execStatements(
+				`UPDATE records SET
+					sealed_from = COALESCE(
+						(SELECT MIN(version) FROM record_versions WHERE record_id = records.id AND hash != ''),
+						(SELECT MAX(version) + 1 FROM record_versions WHERE record_id = records.id)
+					),
+					head_version = (SELECT MAX(version) FROM record_versions WHERE record_id = records.id),
+					head_hash = (SELECT hash FROM record_versions WHERE record_id = records.id ORDER BY version DESC LIMIT 1)`,
+			)(tx)
+		},
+	},
+	{
+		// Keyframe events carry the full state of their record, as every
+		// event did before. Other events carry only the keys their change
+		// set or removed, applied to the state before them.
+		Version: 13,
+		Name:    "add keyframe to record_events",
+		Up: func(tx *sql.Tx) error {
+			return addColumn(tx, "record_events", "keyframe", "BOOLEAN NOT NULL DEFAULT 1")
+		},
+	},
+}
+
+// Migrations returns every migration known to this binary, in order
+func Migrations() []Migration {
+	return append([]Migration(nil), migrations...)
+}
+
+// SchemaVersion returns the version of the latest migration applied to the
+// database, or 0 if none has been. It only reads the database, so it can be
+// used on a database that must not be changed.
+func (db *DB) SchemaVersion() (int, error) {
+	var tracked bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')").Scan(&tracked)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect schema: %w", err)
+	}
+	if !tracked {
+		return 0, nil
+	}
+
+	var version int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query schema version: %w", err)
+	}
+	return version, nil
+}
+
+// PendingMigrations returns the migrations not yet applied to the database.
+// It fails with ErrSchemaTooNew if the database was migrated by a newer binary.
+func (db *DB) PendingMigrations() ([]Migration, error) {
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	latest := migrations[len(migrations)-1].Version
+	if current > latest {
+		return nil, fmt.Errorf("%w: database is at version %d, binary supports up to %d", ErrSchemaTooNew, current, latest)
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies every pending migration in order. Other processes may be
+// migrating the same database at the same time: each migration is skipped if
+// another process applied it first.
+func (db *DB) Migrate() error {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return err
+	}
+
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		if err := db.apply(m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// apply runs a migration and records it in a single transaction, unless it
+// was applied since the pending migrations were listed. The transaction
+// starts with BEGIN IMMEDIATE, so it holds the write lock from the check on
+// and concurrent migrations are serialized.
+func (db *DB) apply(m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var current int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to query schema version: %w", err)
+	}
+	if current >= m.Version {
+		return nil
+	}
+
+	if err := m.Up(tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ensureMigrationsTable creates the table tracking applied migrations
+func (db *DB) ensureMigrationsTable() error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// execStatements returns a migration step running each statement in order
+func execStatements(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumn adds a column to a table unless it already exists
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
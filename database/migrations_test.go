@@ -0,0 +1,70 @@
+package database
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestMigrateConcurrently migrates one new database from several processes'
+// worth of connections at once, as servers started together would
+func TestMigrateConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timetravel.db")
+
+	const processes = 8
+	var wg sync.WaitGroup
+	for i := 0; i < processes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db, err := NewDB(path)
+			if err != nil {
+				t.Errorf("NewDB: %v", err)
+				return
+			}
+			db.Close()
+		}()
+	}
+	wg.Wait()
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	var applied int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatalf("count migrations: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("%d migrations recorded, want %d", applied, len(migrations))
+	}
+}
+
+// TestSchemaVersionReadOnly checks that reading the schema version of a
+// database that was never migrated leaves it untouched
+func TestSchemaVersionReadOnly(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "timetravel.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	if err != nil || version != 0 {
+		t.Fatalf("SchemaVersion = %d, %v; want 0", version, err)
+	}
+	pending, err := db.PendingMigrations()
+	if err != nil || len(pending) != len(migrations) {
+		t.Fatalf("PendingMigrations = %d migrations, %v; want %d", len(pending), err, len(migrations))
+	}
+
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&tables); err != nil {
+		t.Fatalf("count tables: %v", err)
+	}
+	if tables != 0 {
+		t.Errorf("database has %d schema objects, want none", tables)
+	}
+}
@@ -0,0 +1,30 @@
+package entity
+
+import "time"
+
+// ValueChange describes how the value of a single key changed. A nil value
+// means the key was absent.
+type ValueChange struct {
+	Old *string `json:"old"`
+	New *string `json:"new"`
+}
+
+// CorrectedInterval describes how a retroactive correction changed a record
+// over one interval of valid time
+type CorrectedInterval struct {
+	// Version is the version recorded with the corrected state of the interval
+	Version int `json:"version"`
+	// Replaces is the version previously in effect over the interval, or 0
+	// if the record did not exist yet
+	Replaces  int                    `json:"replaces,omitempty"`
+	ValidFrom time.Time              `json:"valid_from"`
+	ValidTo   *time.Time             `json:"valid_to"`
+	Changes   map[string]ValueChange `json:"changes"`
+}
+
+// CorrectionReport lists the intervals a retroactive correction changed
+type CorrectionReport struct {
+	ID            int                 `json:"id"`
+	EffectiveFrom time.Time           `json:"effective_from"`
+	Intervals     []CorrectedInterval `json:"intervals"`
+}
@@ -0,0 +1,11 @@
+package entity
+
+// RecordDiff describes what changed in a record between two versions
+type RecordDiff struct {
+	ID      int                    `json:"id"`
+	From    int                    `json:"from"`
+	To      int                    `json:"to"`
+	Added   map[string]string      `json:"added"`
+	Removed map[string]string      `json:"removed"`
+	Changed map[string]ValueChange `json:"changed"`
+}
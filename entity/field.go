@@ -0,0 +1,21 @@
+package entity
+
+import "time"
+
+// Kinds of change a version can make to a single key
+const (
+	FieldAdded   = "added"
+	FieldChanged = "changed"
+	FieldRemoved = "removed"
+)
+
+// FieldChange describes a version in which a key of a record was added,
+// changed or removed
+type FieldChange struct {
+	Version       int       `json:"version"`
+	CreatedAt     time.Time `json:"created_at"`
+	EffectiveFrom time.Time `json:"effective_from"`
+	Change        string    `json:"change"`
+	Old           *string   `json:"old"`
+	New           *string   `json:"new"`
+}
@@ -0,0 +1,11 @@
+package entity
+
+import "time"
+
+// Index is a key of record data that records can be looked up by
+type Index struct {
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+	// Records is how many records that are not deleted hold the key
+	Records int `json:"records"`
+}
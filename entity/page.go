@@ -0,0 +1,9 @@
+package entity
+
+// RecordPage is one page of a listing of records in ID order
+type RecordPage struct {
+	Records []Record `json:"records"`
+	// NextCursor continues the listing after the last record of the page. It
+	// is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,30 @@
+package entity
+
+import "time"
+
+// RetentionPolicy limits how much of a record's history is kept. A version is
+// kept if it is among the KeepLast most recent versions, or if it was still
+// current less than KeepDays days ago. A zero field sets no limit of its own,
+// so a policy with both fields zero keeps everything. The latest version is
+// always kept.
+type RetentionPolicy struct {
+	KeepLast int `json:"keep_last,omitempty"`
+	KeepDays int `json:"keep_days,omitempty"`
+}
+
+// Retention is the retention configuration of a record
+type Retention struct {
+	ID int `json:"id"`
+	// Policy overrides the global policy; nil if the record follows it
+	Policy *RetentionPolicy `json:"policy"`
+	// LegalHold blocks all pruning of the record's history while set
+	LegalHold bool `json:"legal_hold"`
+}
+
+// Compaction describes the oldest versions of a record, FromVersion through
+// ToVersion, that were removed by retention
+type Compaction struct {
+	FromVersion int       `json:"from_version"`
+	ToVersion   int       `json:"to_version"`
+	CompactedAt time.Time `json:"compacted_at"`
+}
@@ -0,0 +1,8 @@
+package entity
+
+// Tag is a named label pointing at one version of a record
+type Tag struct {
+	ID      int    `json:"id"`
+	Tag     string `json:"tag"`
+	Version int    `json:"version"`
+}
@@ -0,0 +1,21 @@
+package entity
+
+// Verification is the result of checking the hash chain of a record's versions
+type Verification struct {
+	ID    int  `json:"id"`
+	Valid bool `json:"valid"`
+	// Versions is the number of versions checked
+	Versions int `json:"versions"`
+	// Unsealed is the number of versions written before versions were
+	// hashed, which cannot be verified. Only versions before the first
+	// version the record keeps as sealed may be unsealed.
+	Unsealed int `json:"unsealed,omitempty"`
+	// Broken is the first link of the chain that failed, nil if it is valid
+	Broken *BrokenLink `json:"broken,omitempty"`
+}
+
+// BrokenLink identifies the first version whose hash does not hold
+type BrokenLink struct {
+	Version int    `json:"version"`
+	Reason  string `json:"reason"`
+}
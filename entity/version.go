@@ -3,16 +3,56 @@ package entity
 import "time"
 
 // RecordVersion represents a specific version of a record
+//
+// CreatedAt is the transaction time at which the version was recorded, while
+// EffectiveFrom is the valid time from which its data was true.
 type RecordVersion struct {
-	ID        int               `json:"id"`
-	RecordID  int               `json:"record_id"`
-	Version   int               `json:"version"`
-	Data      map[string]string `json:"data"`
-	CreatedAt time.Time         `json:"created_at"`
+	ID            int               `json:"id"`
+	RecordID      int               `json:"record_id"`
+	Version       int               `json:"version"`
+	Data          map[string]string `json:"data"`
+	CreatedAt     time.Time         `json:"created_at"`
+	EffectiveFrom time.Time         `json:"effective_from"`
+	RestoredFrom  int               `json:"restored_from,omitempty"`
+	// Deleted marks a tombstone, recorded when the record was deleted. Its
+	// data is empty.
+	Deleted bool `json:"deleted,omitempty"`
+	// Hash seals the version's contents to the version before it
+	Hash string `json:"hash,omitempty"`
+	ChangeMetadata
 }
 
 // VersionInfo contains metadata about a version
 type VersionInfo struct {
-	Version   int       `json:"version"`
-	CreatedAt time.Time `json:"created_at"`
+	Version       int       `json:"version"`
+	CreatedAt     time.Time `json:"created_at"`
+	EffectiveFrom time.Time `json:"effective_from"`
+	// RestoredFrom is the earlier version whose data this version restored
+	RestoredFrom int `json:"restored_from,omitempty"`
+	// Deleted marks a tombstone, recorded when the record was deleted
+	Deleted bool `json:"deleted,omitempty"`
+	// Tags are the names currently pointing at this version
+	Tags []string `json:"tags,omitempty"`
+	// Compacted is set on the marker left in place of versions removed by
+	// retention, which carries the number and times of the last of them
+	Compacted *Compaction `json:"compacted,omitempty"`
+	// Hash seals the version's contents to the version before it
+	Hash string `json:"hash,omitempty"`
+	ChangeMetadata
+}
+
+// ChangeMetadata records who made a change, why, and through which system
+type ChangeMetadata struct {
+	Author string `json:"author,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+// TimelineEntry is a version together with the interval of valid time over
+// which it was in effect. ValidTo is the start of the next entry, or nil for
+// the current, open-ended one.
+type TimelineEntry struct {
+	VersionInfo
+	ValidFrom time.Time  `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to"`
 }
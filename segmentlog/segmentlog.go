@@ -0,0 +1,468 @@
+// Package segmentlog is an append-only log of opaque entries stored in a
+// directory of segment files. It is pure Go.
+//
+// Entries are appended to the newest segment, and a new segment is started
+// once it grows past a size limit. Every entry is framed with its length and
+// a CRC-32C checksum and synced to disk before Append returns, so a write cut
+// short by a crash is detected and discarded when the log is opened again.
+// An append that fails is cut off the segment again; if that fails too, the
+// log refuses further appends until it is compacted or reopened.
+//
+// The log does not interpret its entries. Compact replaces every segment with
+// a snapshot written by the caller, which must hold the same state as the
+// entries it replaces.
+package segmentlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrCorrupt = errors.New("segment log is corrupt")
+	ErrClosed  = errors.New("segment log is closed")
+	ErrFailed  = errors.New("segment log cannot be appended to after a failure; compact or reopen it")
+)
+
+const (
+	segmentExt  = ".log"
+	snapshotExt = ".snapshot"
+	tmpExt      = ".tmp"
+
+	// frameHeaderSize is the size of the length and checksum preceding an entry
+	frameHeaderSize = 8
+	// maxEntrySize bounds the length read from a frame header, so a corrupt
+	// header is not mistaken for a huge entry
+	maxEntrySize = 1 << 30
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configures a Log
+type Options struct {
+	// MaxSegmentSize is the size in bytes past which a new segment is
+	// started. Defaults to 16 MiB.
+	MaxSegmentSize int64
+
+	// CompactAfter is the number of full segments written since the last
+	// snapshot at which NeedsCompaction reports true. Defaults to 4; a
+	// negative value never reports it.
+	CompactAfter int
+}
+
+// Log is an append-only log in a directory of segment files. It is safe for
+// concurrent use, but a directory must only be opened by one Log at a time.
+type Log struct {
+	mu      sync.Mutex
+	dir     string
+	opts    Options
+	active  *os.File
+	size    int64
+	number  int
+	sealed  int
+	discard int64
+	// failed is set when a failed append could not be cut off the active
+	// segment, so appending after it could bury a bad frame mid-log, or when
+	// a compaction replaced the active segment but could not start another
+	failed bool
+}
+
+// file is a segment or snapshot file of the log
+type file struct {
+	number   int
+	snapshot bool
+}
+
+// name returns the name of the file in the log directory
+func (f file) name() string {
+	ext := segmentExt
+	if f.snapshot {
+		ext = snapshotExt
+	}
+	return fmt.Sprintf("%016d%s", f.number, ext)
+}
+
+// Open opens the log in dir, creating it if needed, and calls replay with
+// every entry in the order it was appended: first the latest snapshot, then
+// the segments written after it. A torn entry at the end of the newest
+// segment is discarded; damage anywhere else fails with ErrCorrupt.
+func Open(dir string, opts Options, replay func(entry []byte) error) (*Log, error) {
+	if opts.MaxSegmentSize <= 0 {
+		opts.MaxSegmentSize = 16 << 20
+	}
+	if opts.CompactAfter == 0 {
+		opts.CompactAfter = 4
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Everything before the latest snapshot was replaced by it. Files left
+	// behind by a compaction interrupted before it removed them are
+	// removed now.
+	start := 0
+	for i, f := range files {
+		if f.snapshot {
+			start = i
+		}
+	}
+	for _, f := range files[:start] {
+		if err := os.Remove(filepath.Join(dir, f.name())); err != nil {
+			return nil, fmt.Errorf("failed to remove compacted file: %w", err)
+		}
+	}
+	files = files[start:]
+
+	l := &Log{dir: dir, opts: opts}
+	for i, f := range files {
+		last := i == len(files)-1
+		valid, err := replayFile(filepath.Join(dir, f.name()), replay)
+		if errors.Is(err, ErrCorrupt) && last && !f.snapshot {
+			// The entry being written when the process stopped
+			l.discard, err = truncate(filepath.Join(dir, f.name()), valid)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !f.snapshot && !last {
+			l.sealed++
+		}
+	}
+
+	// Continue the newest segment, unless it is full or there is none
+	if n := len(files); n > 0 && !files[n-1].snapshot {
+		f := files[n-1]
+		info, err := os.Stat(filepath.Join(dir, f.name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open segment: %w", err)
+		}
+		if info.Size() < opts.MaxSegmentSize {
+			if err := l.openSegment(f.number, info.Size()); err != nil {
+				return nil, err
+			}
+			return l, nil
+		}
+		l.sealed++
+	}
+
+	next := 1
+	if n := len(files); n > 0 {
+		next = files[n-1].number + 1
+	}
+	if err := l.openSegment(next, 0); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// listFiles returns the segment and snapshot files in dir in log order,
+// removing temporary files left by an interrupted compaction
+func listFiles(dir string) ([]file, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log directory: %w", err)
+	}
+
+	var files []file
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, tmpExt) {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return nil, fmt.Errorf("failed to remove temporary file: %w", err)
+			}
+			continue
+		}
+
+		ext := filepath.Ext(name)
+		if ext != segmentExt && ext != snapshotExt {
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimSuffix(name, ext))
+		if err != nil || number <= 0 {
+			continue
+		}
+		files = append(files, file{number: number, snapshot: ext == snapshotExt})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].number < files[j].number
+	})
+	return files, nil
+}
+
+// replayFile calls replay with every entry of a file and returns the size of
+// its valid prefix. It fails with ErrCorrupt at the first damaged entry.
+func replayFile(path string, replay func(entry []byte) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open segment: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var valid int64
+	header := make([]byte, frameHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return valid, nil
+		} else if err != nil {
+			return valid, fmt.Errorf("%w: %s: truncated entry at offset %d", ErrCorrupt, filepath.Base(path), valid)
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		sum := binary.LittleEndian.Uint32(header[4:8])
+		if length > maxEntrySize {
+			return valid, fmt.Errorf("%w: %s: invalid entry length at offset %d", ErrCorrupt, filepath.Base(path), valid)
+		}
+
+		entry := make([]byte, length)
+		if _, err := io.ReadFull(r, entry); err != nil {
+			return valid, fmt.Errorf("%w: %s: truncated entry at offset %d", ErrCorrupt, filepath.Base(path), valid)
+		}
+		if crc32.Checksum(entry, castagnoli) != sum {
+			return valid, fmt.Errorf("%w: %s: checksum mismatch at offset %d", ErrCorrupt, filepath.Base(path), valid)
+		}
+
+		if err := replay(entry); err != nil {
+			return valid, err
+		}
+		valid += frameHeaderSize + int64(length)
+	}
+}
+
+// truncate cuts the file at path to size and returns how many bytes were
+// discarded
+func truncate(path string, size int64) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to recover segment: %w", err)
+	}
+	if err := os.Truncate(path, size); err != nil {
+		return 0, fmt.Errorf("failed to recover segment: %w", err)
+	}
+	return info.Size() - size, nil
+}
+
+// openSegment makes the segment numbered number, holding size bytes, the one
+// entries are appended to. The caller must hold the lock or own the log.
+func (l *Log) openSegment(number int, size int64) error {
+	f, err := os.OpenFile(filepath.Join(l.dir, file{number: number}.name()), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open segment: %w", err)
+	}
+	if size == 0 {
+		// Make sure the new segment survives a crash
+		if err := syncDir(l.dir); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	l.active = f
+	l.number = number
+	l.size = size
+	return nil
+}
+
+// Append writes an entry to the end of the log and syncs it to disk
+func (l *Log) Append(entry []byte) error {
+	if len(entry) > maxEntrySize {
+		return fmt.Errorf("log entry of %d bytes is too large", len(entry))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		return ErrClosed
+	}
+	if l.failed {
+		return ErrFailed
+	}
+
+	frame := make([]byte, frameHeaderSize+len(entry))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(entry)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(entry, castagnoli))
+	copy(frame[frameHeaderSize:], entry)
+
+	if _, err := l.active.Write(frame); err != nil {
+		l.undoAppend()
+		return fmt.Errorf("failed to append to segment: %w", err)
+	}
+	if err := l.active.Sync(); err != nil {
+		l.undoAppend()
+		return fmt.Errorf("failed to sync segment: %w", err)
+	}
+	l.size += int64(len(frame))
+
+	if l.size >= l.opts.MaxSegmentSize {
+		// The entry is already on disk, so a segment that cannot be started
+		// is not an error: appends go on in the full one, and the next append
+		// tries again
+		full := l.active
+		if err := l.openSegment(l.number+1, 0); err != nil {
+			return nil
+		}
+		full.Close()
+		l.sealed++
+	}
+	return nil
+}
+
+// undoAppend cuts a failed append off the end of the active segment, so the
+// entry is not replayed when the log is opened again and later entries are not
+// appended after it. If it cannot be cut off, the log refuses further appends.
+// The caller must hold the lock.
+func (l *Log) undoAppend() {
+	if err := l.active.Truncate(l.size); err != nil {
+		l.failed = true
+		return
+	}
+	if err := l.active.Sync(); err != nil {
+		l.failed = true
+	}
+}
+
+// NeedsCompaction reports whether enough segments were written since the last
+// snapshot for a compaction to be worthwhile
+func (l *Log) NeedsCompaction() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.opts.CompactAfter > 0 && l.sealed >= l.opts.CompactAfter
+}
+
+// Discarded returns the number of bytes of a torn entry that were discarded
+// from the end of the log when it was opened
+func (l *Log) Discarded() int64 {
+	return l.discard
+}
+
+// Compact replaces every entry in the log with a snapshot. write is called
+// with a function appending an entry to the snapshot and must write entries
+// that, replayed in order, recreate the state of the log; nothing can be
+// appended while it runs. The snapshot only replaces the log once it is
+// complete and on disk.
+func (l *Log) Compact(write func(add func(entry []byte) error) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		return ErrClosed
+	}
+
+	// The snapshot takes the place of the active segment in the log order
+	snapshot := file{number: l.number + 1, snapshot: true}
+	path := filepath.Join(l.dir, snapshot.name())
+	f, err := os.OpenFile(path+tmpExt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	err = write(func(entry []byte) error {
+		if len(entry) > maxEntrySize {
+			return fmt.Errorf("log entry of %d bytes is too large", len(entry))
+		}
+		var header [frameHeaderSize]byte
+		binary.LittleEndian.PutUint32(header[0:4], uint32(len(entry)))
+		binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(entry, castagnoli))
+		if _, err := w.Write(header[:]); err != nil {
+			return err
+		}
+		_, err := w.Write(entry)
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + tmpExt)
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	// Once renamed the snapshot is the start of the log, even if the files
+	// it replaces cannot be removed below
+	if err := os.Rename(path+tmpExt, path); err != nil {
+		os.Remove(path + tmpExt)
+		return fmt.Errorf("failed to install snapshot: %w", err)
+	}
+	if err := syncDir(l.dir); err != nil {
+		return err
+	}
+
+	// Entries appended to the replaced segment would be lost, so until a
+	// segment after the snapshot is open the log refuses appends. The
+	// replaced segment stays open for Close.
+	replaced := l.active
+	if err := l.openSegment(snapshot.number+1, 0); err != nil {
+		l.failed = true
+		return err
+	}
+	replaced.Close()
+
+	// The snapshot replaced the segment a failed append was left in
+	l.sealed = 0
+	l.failed = false
+
+	// Files the snapshot replaced that cannot be removed now are removed
+	// when the log is next opened
+	files, err := listFiles(l.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.number < snapshot.number {
+			if err := os.Remove(filepath.Join(l.dir, f.name())); err != nil {
+				return fmt.Errorf("failed to remove compacted file: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		return nil
+	}
+	err := l.active.Close()
+	l.active = nil
+	return err
+}
+
+// syncDir syncs a directory so that files created or renamed in it survive a
+// crash. Not every platform supports it, so failures to sync are ignored.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open log directory: %w", err)
+	}
+	d.Sync()
+	return d.Close()
+}
@@ -0,0 +1,310 @@
+package segmentlog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestAppendFailure makes the active segment unwritable, so an append fails
+// and cannot be cut off, and checks that nothing is appended after it until
+// the log is compacted
+func TestAppendFailure(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir, Options{}, func([]byte) error { return nil })
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := log.Append([]byte("a")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	// Swap in a read-only handle on the same segment
+	readOnly, err := os.Open(filepath.Join(dir, file{number: log.number}.name()))
+	if err != nil {
+		t.Fatalf("open segment: %v", err)
+	}
+	log.active.Close()
+	log.active = readOnly
+
+	if err := log.Append([]byte("b")); err == nil {
+		t.Fatalf("Append to a read-only segment succeeded")
+	}
+	if err := log.Append([]byte("c")); !errors.Is(err, ErrFailed) {
+		t.Fatalf("Append after a failure = %v, want ErrFailed", err)
+	}
+
+	// A snapshot replaces the segment, so appends resume
+	err = log.Compact(func(add func([]byte) error) error {
+		return add([]byte("a"))
+	})
+	if err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if err := log.Append([]byte("d")); err != nil {
+		t.Fatalf("Append after compaction: %v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var got []string
+	log, err = Open(dir, Options{}, func(entry []byte) error {
+		got = append(got, string(entry))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer log.Close()
+	if want := []string{"a", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+}
+
+// openLog opens the log in dir and returns it with the entries it replayed
+func openLog(t *testing.T, dir string, opts Options) (*Log, []string) {
+	t.Helper()
+	var entries []string
+	log, err := Open(dir, opts, func(entry []byte) error {
+		entries = append(entries, string(entry))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return log, entries
+}
+
+// appendAll appends every entry to the log or fails the test
+func appendAll(t *testing.T, log *Log, entries ...string) {
+	t.Helper()
+	for _, entry := range entries {
+		if err := log.Append([]byte(entry)); err != nil {
+			t.Fatalf("Append(%s): %v", entry, err)
+		}
+	}
+}
+
+// TestReopen appends entries over several segments and checks that they are
+// replayed in order, and that appends continue after them
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{MaxSegmentSize: 3 * (frameHeaderSize + 1)}
+
+	log, _ := openLog(t, dir, opts)
+	appendAll(t, log, "a", "b", "c", "d", "e", "f", "g")
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	log, got := openLog(t, dir, opts)
+	if want := []string{"a", "b", "c", "d", "e", "f", "g"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+	if log.sealed != 2 {
+		t.Errorf("%d sealed segments after reopening, want 2", log.sealed)
+	}
+	appendAll(t, log, "h")
+	log.Close()
+
+	log, got = openLog(t, dir, opts)
+	defer log.Close()
+	if want := []string{"a", "b", "c", "d", "e", "f", "g", "h"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q after appending, want %q", got, want)
+	}
+}
+
+// TestTornTail cuts the last entry short, as a crash while appending would,
+// and checks that it is discarded and cut off the segment
+func TestTornTail(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := openLog(t, dir, Options{})
+	appendAll(t, log, "first", "second")
+	path := filepath.Join(dir, file{number: log.number}.name())
+	log.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat segment: %v", err)
+	}
+	if err := os.Truncate(path, info.Size()-2); err != nil {
+		t.Fatalf("tear segment: %v", err)
+	}
+
+	log, got := openLog(t, dir, Options{})
+	if want := []string{"first"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+	if want := int64(frameHeaderSize + len("second") - 2); log.Discarded() != want {
+		t.Errorf("Discarded = %d, want %d", log.Discarded(), want)
+	}
+	appendAll(t, log, "third")
+	log.Close()
+
+	log, got = openLog(t, dir, Options{})
+	defer log.Close()
+	if want := []string{"first", "third"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q after appending, want %q", got, want)
+	}
+	if log.Discarded() != 0 {
+		t.Errorf("Discarded = %d after the tear was cut off, want 0", log.Discarded())
+	}
+
+	// Damage before the end of the log is not a torn write
+	log.Close()
+	if err := os.WriteFile(path, []byte("garbage!"), 0644); err != nil {
+		t.Fatalf("damage segment: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, file{number: log.number + 1}.name()), nil, 0644); err != nil {
+		t.Fatalf("add segment: %v", err)
+	}
+	if _, err := Open(dir, Options{}, func([]byte) error { return nil }); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open with a damaged sealed segment = %v, want ErrCorrupt", err)
+	}
+}
+
+// TestCompact replaces the log with a snapshot and checks that the files it
+// replaced are removed and the snapshot is replayed before later entries
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{MaxSegmentSize: 2 * (frameHeaderSize + 1)}
+	log, _ := openLog(t, dir, opts)
+	appendAll(t, log, "a", "b", "c", "d", "e")
+
+	err := log.Compact(func(add func([]byte) error) error {
+		return add([]byte("abcde"))
+	})
+	if err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if log.NeedsCompaction() {
+		t.Errorf("NeedsCompaction after compacting")
+	}
+	appendAll(t, log, "f")
+	log.Close()
+
+	files, err := listFiles(dir)
+	if err != nil {
+		t.Fatalf("listFiles: %v", err)
+	}
+	if len(files) != 2 || !files[0].snapshot || files[1].snapshot {
+		t.Errorf("files after compaction = %+v, want a snapshot and a segment", files)
+	}
+
+	log, got := openLog(t, dir, opts)
+	defer log.Close()
+	if want := []string{"abcde", "f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+
+	// A snapshot that fails leaves the log as it was
+	err = log.Compact(func(add func([]byte) error) error {
+		return errors.New("snapshot failed")
+	})
+	if err == nil {
+		t.Fatalf("Compact with a failing snapshot succeeded")
+	}
+	appendAll(t, log, "g")
+}
+
+// TestCompactCannotRemove keeps a file the snapshot replaced from being
+// removed, and checks that the log still appends after the snapshot
+func TestCompactCannotRemove(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := openLog(t, dir, Options{})
+	appendAll(t, log, "a")
+
+	// A directory that is not empty cannot be removed
+	blocker := filepath.Join(dir, file{number: log.number, snapshot: true}.name())
+	if err := os.MkdirAll(filepath.Join(blocker, "file"), 0755); err != nil {
+		t.Fatalf("create blocker: %v", err)
+	}
+	err := log.Compact(func(add func([]byte) error) error {
+		return add([]byte("a"))
+	})
+	if err == nil {
+		t.Fatalf("Compact removed a directory that is not empty")
+	}
+	appendAll(t, log, "b")
+	log.Close()
+
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatalf("remove blocker: %v", err)
+	}
+	log, got := openLog(t, dir, Options{})
+	defer log.Close()
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+}
+
+// TestRolloverFailure keeps the next segment from being created and checks
+// that appends go on in the full segment until it can be
+func TestRolloverFailure(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{MaxSegmentSize: frameHeaderSize + 1}
+	log, _ := openLog(t, dir, opts)
+
+	// A directory in place of the next segment cannot be opened for writing
+	blocker := filepath.Join(dir, file{number: log.number + 1}.name())
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatalf("create blocker: %v", err)
+	}
+	appendAll(t, log, "a", "b")
+	if log.number != 1 {
+		t.Errorf("appending to segment %d, want the full segment 1", log.number)
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("remove blocker: %v", err)
+	}
+	appendAll(t, log, "c", "d")
+	log.Close()
+
+	log, got := openLog(t, dir, opts)
+	defer log.Close()
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+}
+
+// TestCompactCannotStartSegment keeps the segment after a snapshot from being
+// created, and checks that nothing is appended to the segment the snapshot
+// replaced until a compaction succeeds
+func TestCompactCannotStartSegment(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := openLog(t, dir, Options{})
+	appendAll(t, log, "a")
+
+	blocker := filepath.Join(dir, file{number: log.number + 2}.name())
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatalf("create blocker: %v", err)
+	}
+	snapshot := func(add func([]byte) error) error {
+		return add([]byte("a"))
+	}
+	if err := log.Compact(snapshot); err == nil {
+		t.Fatalf("Compact started a segment in place of a directory")
+	}
+	if err := log.Append([]byte("b")); !errors.Is(err, ErrFailed) {
+		t.Fatalf("Append after a failed compaction = %v, want ErrFailed", err)
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("remove blocker: %v", err)
+	}
+	if err := log.Compact(snapshot); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	appendAll(t, log, "c")
+	log.Close()
+
+	log, got := openLog(t, dir, Options{})
+	defer log.Close()
+	if want := []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+}
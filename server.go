@@ -1,18 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rainbowmga/timetravel/api"
+	"github.com/rainbowmga/timetravel/api/admin"
 	v2api "github.com/rainbowmga/timetravel/api/v2"
 	"github.com/rainbowmga/timetravel/database"
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/segmentlog"
 	"github.com/rainbowmga/timetravel/service"
 )
 
+// adminTokenEnv names the environment variable holding the token the admin
+// API requires. It is not a flag so it does not show up in process listings.
+const adminTokenEnv = "TIMETRAVEL_ADMIN_TOKEN"
+
 // logError logs all non-nil errors
 func logError(err error) {
 	if err != nil {
@@ -21,10 +33,115 @@ func logError(err error) {
 }
 
 func main() {
+	storage := flag.String("storage", "sqlite", "storage backend: sqlite, or file for a segment log that needs no cgo")
+	dbPath := flag.String("db", database.DefaultDBPath, "path to the SQLite database")
+	dataDir := flag.String("data-dir", "timetravel-data", "directory of the segment log used by the file storage backend")
+	var retention entity.RetentionPolicy
+	flag.IntVar(&retention.KeepLast, "retain-versions", 0, "keep at least this many of the latest versions of each record (0 keeps all)")
+	flag.IntVar(&retention.KeepDays, "retain-days", 0, "keep versions that were current within this many days (0 keeps all)")
+	compactInterval := flag.Duration("compact-interval", time.Hour, "how often the server prunes versions no longer kept by retention, or compacts the segment log (0 disables)")
+	index := flag.String("index", "", "comma-separated keys of record data the server indexes at startup, backfilling keys not indexed yet")
+	backupDir := flag.String("backup-dir", "backups", "directory the admin API writes backups to")
+	flag.Usage = usage
+	flag.Parse()
+
+	command := flag.Arg(0)
+	switch *storage {
+	case "sqlite":
+	case "file":
+		// Every other command works on the SQLite database
+		if command != "" && command != "serve" && command != "compact" {
+			log.Fatalf("%s is not supported by the file storage backend", command)
+		}
+		if *index != "" {
+			log.Fatal("indexes are not supported by the file storage backend")
+		}
+	default:
+		log.Fatalf("unknown storage backend %q; use sqlite or file", *storage)
+	}
+
+	var err error
+	switch command {
+	case "", "serve":
+		if *storage == "file" {
+			err = serveFile(*dataDir, *compactInterval)
+		} else {
+			err = serve(*dbPath, retention, *compactInterval, indexKeys(*index), *backupDir, os.Getenv(adminTokenEnv))
+		}
+	case "migrations":
+		err = listMigrations(*dbPath)
+	case "compact":
+		if *storage == "file" {
+			err = compactLog(*dataDir)
+		} else {
+			err = compact(*dbPath, retention)
+		}
+	case "verify":
+		err = verify(*dbPath)
+	case "rebuild":
+		err = rebuild(*dbPath)
+	case "backup", "restore":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		if command == "backup" {
+			err = backup(*dbPath, flag.Arg(1))
+		} else {
+			err = restore(*dbPath, flag.Arg(1))
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// usage prints the command line help
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: timetravel [flags] [command]
+
+commands:
+  serve       run the HTTP server (default)
+  migrations  list the schema migrations not yet applied to the database
+  compact     prune the versions no longer kept by retention once and exit;
+              with -storage file, compact the segment log instead; stop
+              the server first
+  verify      check the hash chain of every record's versions
+  rebuild     rebuild the current state of every record from the change-event
+              log, repairing records that drifted from it
+  backup <path>
+              write a consistent copy of the database to path; safe to run
+              while the server is up
+  restore <path>
+              replace the database with the backup at path after validating
+              it; stop the server first
+
+The admin API, which takes backups into -backup-dir, is served only if
+%s is set, and every request to it must send that token
+as "Authorization: Bearer <token>".
+
+The file storage backend supports serve and compact only. It keeps every
+record in memory and does not support retention, verification, backups or
+indexes.
+
+flags:
+`, adminTokenEnv)
+	flag.PrintDefaults()
+}
+
+// serve runs the HTTP server, pruning history by the global retention
+// policy and per-record overrides every compactInterval. Each of keys is
+// indexed before the server starts listening. The admin API writes backups to
+// backupDir and is served only if adminToken is set.
+func serve(dbPath string, retention entity.RetentionPolicy, compactInterval time.Duration, keys []string, backupDir, adminToken string) error {
 	// Initialize database
-	db, err := database.NewDB(database.DefaultDBPath)
+	db, err := database.NewDB(dbPath)
 	if err != nil {
-		log.Fatalf("failed to initialize database: %v", err)
+		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
@@ -32,16 +149,81 @@ func main() {
 		}
 	}()
 
-	router := mux.NewRouter()
+	// Use SQLiteVersionedRecordService for both APIs (with versioning)
+	versionedService := service.NewSQLiteVersionedRecordService(db)
 
-	// Use SQLiteRecordService for v1 API (backward compatibility)
-	recordService := service.NewSQLiteRecordService(db)
-	v1API := api.NewAPI(recordService)
+	for _, key := range keys {
+		index, err := versionedService.AddIndex(context.Background(), key)
+		if err != nil {
+			return fmt.Errorf("failed to index %q: %w", key, err)
+		}
+		log.Printf("indexed %q: %d records", index.Key, index.Records)
+	}
+
+	// Enforce retention in the background
+	if compactInterval > 0 {
+		go compactEvery(versionedService, retention, compactInterval)
+	}
+
+	router := newRouter(versionedService)
+
+	// Register admin routes, which need a token of their own
+	if adminToken != "" {
+		adminAPI := admin.NewAPI(db, backupDir, adminToken)
+		adminRoute := router.PathPrefix("/api/admin").Subrouter()
+		adminAPI.CreateRoutes(adminRoute)
+	} else {
+		log.Printf("admin API disabled; set %s to enable it", adminTokenEnv)
+	}
+
+	return listen(router)
+}
+
+// indexKeys returns the keys listed in the value of the -index flag
+func indexKeys(list string) []string {
+	var keys []string
+	for _, key := range strings.Split(list, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// serveFile runs the HTTP server on the file storage backend, compacting its
+// segment log every compactInterval if enough was written since the last time
+func serveFile(dataDir string, compactInterval time.Duration) error {
+	versionedService, err := service.NewFileVersionedRecordService(dataDir, segmentlog.Options{})
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	defer func() {
+		if err := versionedService.Close(); err != nil {
+			log.Printf("error closing storage: %v", err)
+		}
+	}()
+	if n := versionedService.Discarded(); n > 0 {
+		log.Printf("discarded %d bytes of an incomplete write from the end of the log", n)
+	}
+
+	if compactInterval > 0 {
+		go compactLogEvery(versionedService, compactInterval)
+	}
+
+	return listen(newRouter(versionedService))
+}
+
+// newRouter returns a router serving the v1 and v2 APIs from versionedService
+func newRouter(versionedService service.VersionedRecordService) *mux.Router {
+	router := mux.NewRouter()
 
-	// Use SQLiteVersionedRecordService for v2 API (with versioning)
-	versionedService := service.NewSQLiteVersionedRecordService(db)
 	v2API := v2api.NewAPI(versionedService)
 
+	// v1 API (backward compatibility) writes through the versioned service
+	// too, so its changes show up in the history with source "v1"
+	recordService := service.NewSourceRecordService(versionedService, "v1")
+	v1API := api.NewAPI(recordService)
+
 	// Register v1 routes
 	v1Route := router.PathPrefix("/api/v1").Subrouter()
 	v1Route.Path("/health").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -54,6 +236,11 @@ func main() {
 	v2Route := router.PathPrefix("/api/v2").Subrouter()
 	v2API.CreateRoutes(v2Route)
 
+	return router
+}
+
+// listen serves router until the server fails
+func listen(router *mux.Router) error {
 	address := "127.0.0.1:8000"
 	srv := &http.Server{
 		Handler:      router,
@@ -63,5 +250,40 @@ func main() {
 	}
 
 	log.Printf("listening on %s", address)
-	log.Fatal(srv.ListenAndServe())
+	return srv.ListenAndServe()
+}
+
+// compactEvery prunes history no longer kept by retention every interval
+func compactEvery(retention service.RetentionService, global entity.RetentionPolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		pruned, err := retention.Compact(context.Background(), global)
+		if err != nil {
+			log.Printf("error: compaction failed: %v", err)
+			continue
+		}
+		if pruned > 0 {
+			log.Printf("compaction pruned %d versions", pruned)
+		}
+	}
+}
+
+// compactLogEvery compacts the segment log every interval if enough was
+// written since it was last compacted
+func compactLogEvery(storage *service.FileVersionedRecordService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !storage.NeedsCompaction() {
+			continue
+		}
+		if err := storage.CompactLog(); err != nil {
+			log.Printf("error: log compaction failed: %v", err)
+			continue
+		}
+		log.Printf("compacted the segment log")
+	}
 }
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/rainbowmga/timetravel/api/apitest"
+	"github.com/rainbowmga/timetravel/database"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// TestV1Contract checks the v1 contract against the router the server runs
+func TestV1Contract(t *testing.T) {
+	apitest.TestV1Contract(t, func(t *testing.T) http.Handler {
+		db, err := database.NewDB(filepath.Join(t.TempDir(), "timetravel.db"))
+		if err != nil {
+			t.Fatalf("NewDB: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return newRouter(service.NewSQLiteVersionedRecordService(db))
+	})
+}
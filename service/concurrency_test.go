@@ -0,0 +1,61 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rainbowmga/timetravel/database"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// TestSQLiteUpsertsAcrossConnections upserts one record concurrently through
+// two databases opened on the same file, as two server processes would. The
+// conformance suite's ConcurrentUpserts shares a single connection pool.
+func TestSQLiteUpsertsAcrossConnections(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "timetravel.db")
+
+	var services []*service.SQLiteVersionedRecordService
+	for i := 0; i < 2; i++ {
+		db, err := database.NewDB(path)
+		if err != nil {
+			t.Fatalf("NewDB: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		services = append(services, service.NewSQLiteVersionedRecordService(db))
+	}
+
+	const writers = 40
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := "value"
+			updates := map[string]*string{fmt.Sprintf("key%d", i): &value}
+			if _, err := services[i%2].UpsertRecord(ctx, 1, updates); err != nil {
+				t.Errorf("UpsertRecord: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got, err := services[0].GetRecord(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetRecord: %v", err)
+	}
+	if len(got.Data) != writers {
+		t.Errorf("record has %d keys, want %d; updates were lost", len(got.Data), writers)
+	}
+
+	versions, err := services[1].ListVersions(ctx, 1)
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+	if len(versions) != writers {
+		t.Errorf("record has %d versions, want %d", len(versions), writers)
+	}
+}
@@ -0,0 +1,65 @@
+package service_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/rainbowmga/timetravel/database"
+	"github.com/rainbowmga/timetravel/segmentlog"
+	"github.com/rainbowmga/timetravel/service"
+	"github.com/rainbowmga/timetravel/service/servicetest"
+)
+
+// newTestDB returns a migrated database in a temporary directory, closed when
+// the test ends
+func newTestDB(t testing.TB) *database.DB {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "timetravel.db"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteVersionedRecordService(t *testing.T) {
+	servicetest.TestVersionedRecordService(t, func(t *testing.T) service.VersionedRecordService {
+		return service.NewSQLiteVersionedRecordService(newTestDB(t))
+	})
+}
+
+func TestInMemoryVersionedRecordService(t *testing.T) {
+	servicetest.TestVersionedRecordService(t, func(t *testing.T) service.VersionedRecordService {
+		return service.NewInMemoryVersionedRecordService()
+	})
+}
+
+func TestFileVersionedRecordService(t *testing.T) {
+	servicetest.TestVersionedRecordService(t, func(t *testing.T) service.VersionedRecordService {
+		// Small segments, so the suite writes across several of them
+		s, err := service.NewFileVersionedRecordService(t.TempDir(), segmentlog.Options{MaxSegmentSize: 4096})
+		if err != nil {
+			t.Fatalf("NewFileVersionedRecordService: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestSQLiteRecordService(t *testing.T) {
+	servicetest.TestRecordService(t, func(t *testing.T) service.RecordService {
+		return service.NewSQLiteRecordService(newTestDB(t))
+	})
+}
+
+func TestInMemoryRecordService(t *testing.T) {
+	servicetest.TestRecordService(t, func(t *testing.T) service.RecordService {
+		return service.NewInMemoryRecordService()
+	})
+}
+
+func TestSourceRecordService(t *testing.T) {
+	servicetest.TestRecordService(t, func(t *testing.T) service.RecordService {
+		return service.NewSourceRecordService(service.NewInMemoryVersionedRecordService(), "v1")
+	})
+}
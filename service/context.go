@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+type contextKey int
+
+const (
+	effectiveFromKey contextKey = iota
+	changeMetadataKey
+)
+
+// WithEffectiveFrom returns a copy of ctx that makes versioned writes
+// effective from t instead of from the time they are recorded.
+func WithEffectiveFrom(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, effectiveFromKey, t)
+}
+
+// effectiveFrom returns the effective time carried by ctx, or recordedAt if
+// none was set.
+func effectiveFrom(ctx context.Context, recordedAt time.Time) time.Time {
+	if t, ok := ctx.Value(effectiveFromKey).(time.Time); ok {
+		return t
+	}
+	return recordedAt
+}
+
+// WithChangeMetadata returns a copy of ctx that records metadata with every
+// version written through it.
+func WithChangeMetadata(ctx context.Context, metadata entity.ChangeMetadata) context.Context {
+	return context.WithValue(ctx, changeMetadataKey, metadata)
+}
+
+// changeMetadata returns the change metadata carried by ctx, if any
+func changeMetadata(ctx context.Context) entity.ChangeMetadata {
+	metadata, _ := ctx.Value(changeMetadataKey).(entity.ChangeMetadata)
+	return metadata
+}
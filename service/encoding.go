@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// keyframeInterval is the maximum number of versions between two keyframes.
+//
+// A keyframe stores the full data of a version. Every other version stores
+// only the keys that changed since the version before it, with null marking a
+// removed key, and is reconstructed by applying the deltas since the latest
+// keyframe. Reading a version therefore never applies more than
+// keyframeInterval-1 deltas.
+const keyframeInterval = 16
+
+// encodeVersion returns how the data of a new version should be stored,
+// either in full as a keyframe or as a delta against the previous version.
+// A delta is only used when it is smaller than the full data.
+func encodeVersion(ctx context.Context, tx *sql.Tx, id int, version int, data map[string]string) (string, bool, error) {
+	fullJSON, err := json.Marshal(data)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to marshal record data: %w", err)
+	}
+
+	var lastKeyframe int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(version), 0) FROM record_versions WHERE record_id = ? AND keyframe = 1",
+		id,
+	).Scan(&lastKeyframe)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to query last keyframe: %w", err)
+	}
+	if lastKeyframe == 0 || version-lastKeyframe >= keyframeInterval {
+		return string(fullJSON), true, nil
+	}
+
+	previous, err := readVersion(ctx, tx, id, version-1)
+	if err == ErrVersionDoesNotExist {
+		return string(fullJSON), true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	deltaJSON, err := json.Marshal(deltaData(previous, data))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to marshal record delta: %w", err)
+	}
+	if len(deltaJSON) >= len(fullJSON) {
+		return string(fullJSON), true, nil
+	}
+
+	return string(deltaJSON), false, nil
+}
+
+// deltaData returns the updates that turn old into new
+func deltaData(old, new map[string]string) map[string]*string {
+	delta := map[string]*string{}
+	for key, change := range diffData(old, new) {
+		delta[key] = change.New
+	}
+	return delta
+}
+
+// versionDecoder reconstructs the data of versions read in version order
+type versionDecoder struct {
+	recordID int
+	version  int
+	data     map[string]string
+}
+
+// decode sets the data of v from its stored form. A delta can only be
+// decoded directly after the version it is based on.
+func (d *versionDecoder) decode(v *entity.RecordVersion, stored string, keyframe bool) error {
+	if keyframe {
+		var data map[string]string
+		if err := json.Unmarshal([]byte(stored), &data); err != nil {
+			return fmt.Errorf("failed to unmarshal record data: %w", err)
+		}
+		v.Data = data
+	} else {
+		if d.version == 0 || d.recordID != v.RecordID || d.version != v.Version-1 {
+			return fmt.Errorf("version %v@%v is stored as a delta without a preceding version", v.RecordID, v.Version)
+		}
+		var delta map[string]*string
+		if err := json.Unmarshal([]byte(stored), &delta); err != nil {
+			return fmt.Errorf("failed to unmarshal record delta: %w", err)
+		}
+		v.Data = applyUpdates(d.data, delta)
+	}
+
+	d.recordID = v.RecordID
+	d.version = v.Version
+	d.data = v.Data
+	return nil
+}
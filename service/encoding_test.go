@@ -0,0 +1,186 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// TestVersionEncoding writes enough versions to span several keyframes and
+// checks how each is stored, then reads every one of them back
+func TestVersionEncoding(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := service.NewSQLiteVersionedRecordService(db)
+
+	// want holds the data of each version, from version 1
+	want := []map[string]string{benchmarkData(0)}
+	if err := s.CreateRecord(ctx, entity.Record{ID: 1, Data: want[0]}); err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	for version := 2; version <= 40; version++ {
+		value := fmt.Sprint(version)
+		updates := map[string]*string{fmt.Sprintf("key%03d", version): &value}
+		// A key removed in the last delta before a keyframe is added back
+		// in the keyframe
+		if version == 16 {
+			updates = map[string]*string{"key050": nil}
+		}
+		if version == 17 {
+			updates["key050"] = &value
+		}
+		if _, err := s.UpdateRecord(ctx, 1, updates); err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+
+		data := map[string]string{}
+		for k, v := range want[len(want)-1] {
+			data[k] = v
+		}
+		for k, v := range updates {
+			if v == nil {
+				delete(data, k)
+			} else {
+				data[k] = *v
+			}
+		}
+		want = append(want, data)
+	}
+
+	rows, err := db.Query("SELECT version, keyframe, data FROM record_versions WHERE record_id = 1 ORDER BY version")
+	if err != nil {
+		t.Fatalf("query versions: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var keyframe bool
+		var stored string
+		if err := rows.Scan(&version, &keyframe, &stored); err != nil {
+			t.Fatalf("scan version: %v", err)
+		}
+		if wantKeyframe := (version-1)%16 == 0; keyframe != wantKeyframe {
+			t.Errorf("version %d stored as keyframe = %v, want %v", version, keyframe, wantKeyframe)
+		}
+		var fields map[string]*string
+		if err := json.Unmarshal([]byte(stored), &fields); err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if !keyframe && len(fields) != 1 {
+			t.Errorf("delta of version %d stores %d keys, want only the one changed", version, len(fields))
+		}
+		if keyframe && len(fields) != len(want[version-1]) {
+			t.Errorf("keyframe of version %d stores %d keys, want %d", version, len(fields), len(want[version-1]))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("iterate versions: %v", err)
+	}
+
+	versions, err := s.ListVersions(ctx, 1)
+	if err != nil || len(versions) != len(want) {
+		t.Fatalf("ListVersions = %d versions, %v; want %d", len(versions), err, len(want))
+	}
+	for _, info := range versions {
+		record, err := s.GetRecordVersion(ctx, 1, info.Version)
+		if err != nil || !reflect.DeepEqual(record.Data, want[info.Version-1]) {
+			t.Errorf("GetRecordVersion(%d) = %v, %v; want %v", info.Version, record.Data, err, want[info.Version-1])
+		}
+		// Reads as of a time decode the whole chain in order
+		record, err = s.GetRecordAsOf(ctx, 1, info.CreatedAt)
+		if err != nil || !reflect.DeepEqual(record.Data, want[info.Version-1]) {
+			t.Errorf("GetRecordAsOf(version %d) = %v, %v; want %v", info.Version, record.Data, err, want[info.Version-1])
+		}
+	}
+}
+
+// benchmarkKeys is the number of keys of the records benchmarked
+const benchmarkKeys = 100
+
+// benchmarkData returns record data with benchmarkKeys keys, each holding a
+// value derived from round
+func benchmarkData(round int) map[string]string {
+	data := make(map[string]string, benchmarkKeys)
+	for i := 0; i < benchmarkKeys; i++ {
+		data[fmt.Sprintf("key%03d", i)] = fmt.Sprintf("value %d of round %d", i, round)
+	}
+	return data
+}
+
+// BenchmarkUpdateRecord measures the cost of writing a version. Changing one
+// key stores a delta for most versions; changing every key makes the delta
+// larger than the data, so every version is stored as a keyframe.
+func BenchmarkUpdateRecord(b *testing.B) {
+	ctx := context.Background()
+	for _, c := range []struct {
+		name    string
+		updates func(i int) map[string]*string
+	}{
+		{"delta", func(i int) map[string]*string {
+			value := fmt.Sprint(i)
+			return map[string]*string{"key000": &value}
+		}},
+		{"keyframe", func(i int) map[string]*string {
+			updates := map[string]*string{}
+			for key, value := range benchmarkData(i) {
+				value := value
+				updates[key] = &value
+			}
+			return updates
+		}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			s := service.NewSQLiteVersionedRecordService(newTestDB(b))
+			if err := s.CreateRecord(ctx, entity.Record{ID: 1, Data: benchmarkData(0)}); err != nil {
+				b.Fatalf("CreateRecord: %v", err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.UpdateRecord(ctx, 1, c.updates(i+1)); err != nil {
+					b.Fatalf("UpdateRecord: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGetRecordVersion measures reading a version of a record with 64
+// versions, each changing one key. Versions 1, 17, 33 and 49 are keyframes,
+// and version 32 is the deepest in its chain, reconstructed from version 17
+// and 15 deltas.
+func BenchmarkGetRecordVersion(b *testing.B) {
+	ctx := context.Background()
+	s := service.NewSQLiteVersionedRecordService(newTestDB(b))
+	if err := s.CreateRecord(ctx, entity.Record{ID: 1, Data: benchmarkData(0)}); err != nil {
+		b.Fatalf("CreateRecord: %v", err)
+	}
+	for i := 2; i <= 64; i++ {
+		value := fmt.Sprint(i)
+		if _, err := s.UpdateRecord(ctx, 1, map[string]*string{fmt.Sprintf("key%03d", i%benchmarkKeys): &value}); err != nil {
+			b.Fatalf("UpdateRecord: %v", err)
+		}
+	}
+
+	for _, c := range []struct {
+		name    string
+		version int
+	}{
+		{"keyframe", 17},
+		{"first delta", 18},
+		{"deepest delta", 32},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := s.GetRecordVersion(ctx, 1, c.version); err != nil {
+					b.Fatalf("GetRecordVersion: %v", err)
+				}
+			}
+		})
+	}
+}
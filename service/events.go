@@ -0,0 +1,389 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// Types of the events appended to the change-event log
+const (
+	eventCreated   = "created"
+	eventUpdated   = "updated"
+	eventRestored  = "restored"
+	eventCorrected = "corrected"
+	eventImported  = "imported"
+	eventDeleted   = "deleted"
+	eventUndeleted = "undeleted"
+	eventCompacted = "compacted"
+)
+
+// recordEvent is an entry of the append-only change-event log. The log is the
+// source of truth for the current state of every record: each event carries
+// the state of its record after the change, and the records table is a
+// projection of the latest event of each record. A deleted event carries the
+// last live state of its record, which undeleting it brings back.
+//
+// Like versions, events are stored as keyframes holding the full state or as
+// deltas holding only the keys changed since the event before, so the log
+// does not grow with the size of a record on every write. Retention folds the
+// events of pruned history into a single compacted keyframe.
+type recordEvent struct {
+	RecordID  int
+	Type      string
+	Data      map[string]string
+	CreatedAt time.Time
+	entity.ChangeMetadata
+}
+
+// appendEvent appends an event to the log and applies it to the records
+// projection within the same transaction
+func appendEvent(ctx context.Context, tx *sql.Tx, e recordEvent) error {
+	dataJSON, err := json.Marshal(e.Data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record data: %w", err)
+	}
+
+	stored, keyframe, err := encodeEvent(ctx, tx, e.RecordID, e.Data, string(dataJSON))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO record_events (record_id, type, data, keyframe, created_at, author, reason, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		e.RecordID, e.Type, stored, keyframe, e.CreatedAt, e.Author, e.Reason, e.Source,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to append event: %w", err)
+	}
+
+	return project(ctx, tx, e.RecordID, string(dataJSON), e.CreatedAt, e.CreatedAt, e.Type == eventDeleted)
+}
+
+// encodeEvent returns how the state carried by a new event of a record should
+// be stored, either in full as a keyframe or as a delta against the state the
+// projection holds before the event, which is the state of the event before
+// it. A keyframe is written at least every keyframeInterval events, and a
+// delta is only used when it is smaller than the full state.
+func encodeEvent(ctx context.Context, tx *sql.Tx, id int, data map[string]string, fullJSON string) (string, bool, error) {
+	var hasKeyframe bool
+	var since int
+	err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM record_events WHERE record_id = ? AND keyframe = 1),
+			(SELECT COUNT(*) FROM record_events WHERE record_id = ? AND seq > (
+				SELECT COALESCE(MAX(seq), 0) FROM record_events WHERE record_id = ? AND keyframe = 1))`,
+		id, id, id,
+	).Scan(&hasKeyframe, &since)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to query last keyframe event: %w", err)
+	}
+	if !hasKeyframe || since+1 >= keyframeInterval {
+		return fullJSON, true, nil
+	}
+
+	previous, _, err := readState(ctx, tx, id)
+	if err == ErrRecordDoesNotExist {
+		return fullJSON, true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	deltaJSON, err := json.Marshal(deltaData(previous, data))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to marshal record delta: %w", err)
+	}
+	if len(deltaJSON) >= len(fullJSON) {
+		return fullJSON, true, nil
+	}
+	return string(deltaJSON), false, nil
+}
+
+// decodeEvent returns the state carried by an event from its stored form,
+// given the state carried by the event of the same record before it, if any
+func decodeEvent(previous map[string]string, hasPrevious bool, stored string, keyframe bool) (map[string]string, error) {
+	if keyframe {
+		var data map[string]string
+		if err := json.Unmarshal([]byte(stored), &data); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event data: %w", err)
+		}
+		return data, nil
+	}
+
+	if !hasPrevious {
+		return nil, errors.New("event is stored as a delta without a preceding event")
+	}
+	var delta map[string]*string
+	if err := json.Unmarshal([]byte(stored), &delta); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event delta: %w", err)
+	}
+	return applyUpdates(previous, delta), nil
+}
+
+// compactEvents folds the events of a record recorded up to through into a
+// single keyframe holding the state they led to, so the log keeps nothing of
+// the history retention pruned. The folded event takes the place of the last
+// event it replaces in the log.
+func compactEvents(ctx context.Context, tx *sql.Tx, id int, through time.Time) error {
+	rows, err := tx.QueryContext(ctx,
+		"SELECT seq, type, data, keyframe, created_at FROM record_events WHERE record_id = ? ORDER BY seq ASC",
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query events: %w", err)
+	}
+
+	var folded []int64
+	var state map[string]string
+	var lastType string
+	var lastCreatedAt time.Time
+	for rows.Next() {
+		var seq int64
+		var eventType, stored string
+		var keyframe bool
+		var createdAt time.Time
+		if err := rows.Scan(&seq, &eventType, &stored, &keyframe, &createdAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan event: %w", err)
+		}
+		if createdAt.After(through) {
+			break
+		}
+		data, err := decodeEvent(state, len(folded) > 0, stored, keyframe)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("event %d of record %d: %w", seq, id, err)
+		}
+		folded = append(folded, seq)
+		state, lastType, lastCreatedAt = data, eventType, createdAt
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating events: %w", err)
+	}
+	if len(folded) < 2 {
+		return nil
+	}
+
+	dataJSON, err := json.Marshal(applyUpdates(state, nil))
+	if err != nil {
+		return fmt.Errorf("failed to marshal record data: %w", err)
+	}
+	last := folded[len(folded)-1]
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM record_events WHERE record_id = ? AND seq <= ?",
+		id, last,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to compact events: %w", err)
+	}
+
+	// A deleted record stays deleted
+	eventType := eventCompacted
+	if lastType == eventDeleted {
+		eventType = eventDeleted
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO record_events (seq, record_id, type, data, keyframe, created_at) VALUES (?, ?, ?, ?, 1, ?)",
+		last, id, eventType, string(dataJSON), lastCreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to compact events: %w", err)
+	}
+	return nil
+}
+
+// project makes dataJSON the current state of a record in the records
+// projection, marking the record deleted as of updatedAt if deleted is set.
+// createdAt is ignored if the record has been projected before.
+func project(ctx context.Context, tx *sql.Tx, id int, dataJSON string, createdAt, updatedAt time.Time, deleted bool) error {
+	var deletedAt interface{}
+	if deleted {
+		deletedAt = updatedAt
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO records (id, data, created_at, updated_at, deleted_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at, deleted_at = excluded.deleted_at`,
+		id, dataJSON, createdAt, updatedAt, deletedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update record: %w", err)
+	}
+
+	return reindex(ctx, tx, id, dataJSON, deleted)
+}
+
+// RebuildProjections replays the change-event log and rewrites every row of
+// the records projection that does not match it. A row of a record with no
+// events is never removed, since that would take its history with it;
+// instead its state is imported into the log as it is, as records that
+// predate the log were. It returns the number of records in the log, how many
+// of them had to be repaired and how many were imported. The index entries of
+// every indexed key are rebuilt from the repaired projection.
+func (s *SQLiteVersionedRecordService) RebuildProjections(ctx context.Context) (int, int, int, error) {
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	type state struct {
+		data      map[string]string
+		createdAt time.Time
+		updatedAt time.Time
+		deleted   bool
+	}
+	states := map[int]state{}
+	var ids []int
+
+	rows, err := tx.QueryContext(ctx, "SELECT seq, record_id, type, data, keyframe, created_at FROM record_events ORDER BY seq ASC")
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to query events: %w", err)
+	}
+	for rows.Next() {
+		var seq int64
+		var id int
+		var eventType, stored string
+		var keyframe bool
+		var latest state
+		if err := rows.Scan(&seq, &id, &eventType, &stored, &keyframe, &latest.updatedAt); err != nil {
+			rows.Close()
+			return 0, 0, 0, fmt.Errorf("failed to scan event: %w", err)
+		}
+		latest.deleted = eventType == eventDeleted
+		first, ok := states[id]
+		latest.data, err = decodeEvent(first.data, ok, stored, keyframe)
+		if err != nil {
+			rows.Close()
+			return 0, 0, 0, fmt.Errorf("event %d of record %d: %w", seq, id, err)
+		}
+		if ok {
+			latest.createdAt = first.createdAt
+		} else {
+			latest.createdAt = latest.updatedAt
+			ids = append(ids, id)
+		}
+		states[id] = latest
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, 0, fmt.Errorf("error iterating events: %w", err)
+	}
+
+	// Compare the projection with the log before rewriting anything, so only
+	// rows that drifted are counted as repaired
+	type row struct {
+		data    map[string]string
+		deleted bool
+	}
+	// orphan is the row of a record with no events
+	type orphan struct {
+		id        int
+		data      string
+		updatedAt time.Time
+		deleted   bool
+	}
+	projected := map[int]row{}
+	var orphans []orphan
+	rows, err = tx.QueryContext(ctx, "SELECT id, data, updated_at, deleted_at IS NOT NULL FROM records ORDER BY id")
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to query records: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		var dataJSON string
+		var updatedAt time.Time
+		var deleted bool
+		if err := rows.Scan(&id, &dataJSON, &updatedAt, &deleted); err != nil {
+			rows.Close()
+			return 0, 0, 0, fmt.Errorf("failed to scan record: %w", err)
+		}
+		if _, ok := states[id]; !ok {
+			orphans = append(orphans, orphan{id: id, data: dataJSON, updatedAt: updatedAt, deleted: deleted})
+			continue
+		}
+		// A row that cannot be decoded is left out and rewritten below
+		var data map[string]string
+		if json.Unmarshal([]byte(dataJSON), &data) == nil {
+			projected[id] = row{data: data, deleted: deleted}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, 0, fmt.Errorf("error iterating records: %w", err)
+	}
+
+	// A deleted row keeps its last live state, which is what a deleted event
+	// carries. A row that cannot be decoded is imported with the data of the
+	// record's current version instead.
+	for _, o := range orphans {
+		var data map[string]string
+		if err := json.Unmarshal([]byte(o.data), &data); err != nil {
+			versions, err := loadVersions(ctx, tx, o.id)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			current, _ := versionAsOf(versions, time.Now())
+			dataJSON, err := json.Marshal(applyUpdates(current.Data, nil))
+			if err != nil {
+				return 0, 0, 0, fmt.Errorf("failed to marshal record data: %w", err)
+			}
+			o.data = string(dataJSON)
+		}
+
+		eventType := eventImported
+		if o.deleted {
+			eventType = eventDeleted
+		}
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO record_events (record_id, type, data, keyframe, created_at) VALUES (?, ?, ?, 1, ?)",
+			o.id, eventType, o.data, o.updatedAt,
+		)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to import record %d: %w", o.id, err)
+		}
+		if err := project(ctx, tx, o.id, o.data, o.updatedAt, o.updatedAt, o.deleted); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	repaired := 0
+	for _, id := range ids {
+		latest := states[id]
+		current, ok := projected[id]
+		if ok && current.deleted == latest.deleted && dataEqual(current.data, latest.data) {
+			continue
+		}
+
+		dataJSON, err := json.Marshal(applyUpdates(latest.data, nil))
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to marshal record data: %w", err)
+		}
+		if err := project(ctx, tx, id, string(dataJSON), latest.createdAt, latest.updatedAt, latest.deleted); err != nil {
+			return 0, 0, 0, err
+		}
+		repaired++
+	}
+
+	// Index entries hold nothing the projection does not, so they are
+	// rebuilt whole rather than compared
+	if _, err := tx.ExecContext(ctx, "DELETE FROM record_index_entries"); err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to clear index: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, indexEntries); err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to rebuild index: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return len(ids) + len(orphans), repaired, len(orphans), nil
+}
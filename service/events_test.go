@@ -0,0 +1,171 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// TestRebuildProjections damages the records projection and the index, adds
+// a row with no events, and checks that rebuilding restores both from the
+// event log and imports the row
+func TestRebuildProjections(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := service.NewSQLiteVersionedRecordService(db)
+
+	if _, err := s.AddIndex(ctx, "state"); err != nil {
+		t.Fatalf("AddIndex: %v", err)
+	}
+
+	// Enough writes to store both keyframe and delta events
+	want := map[string]string{"state": "ca", "name": "a name longer than any change"}
+	for i := 0; i < 20; i++ {
+		value := fmt.Sprint(i)
+		updates := map[string]*string{"n": &value}
+		if i == 0 {
+			name := want["name"]
+			updates["name"] = &name
+		}
+		if i == 10 {
+			state := want["state"]
+			updates["state"] = &state
+		}
+		if i == 5 {
+			updates["gone"] = &value
+		}
+		if i == 15 {
+			updates["gone"] = nil
+		}
+		if _, err := s.UpsertRecord(ctx, 1, updates); err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+	}
+	want["n"] = "19"
+
+	state := "tx"
+	if _, err := s.UpsertRecord(ctx, 2, map[string]*string{"state": &state}); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if err := s.DeleteRecord(ctx, 2); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+
+	var keyframes, deltas int
+	err := db.QueryRow("SELECT COUNT(*) FILTER (WHERE keyframe), COUNT(*) FILTER (WHERE NOT keyframe) FROM record_events WHERE record_id = 1").Scan(&keyframes, &deltas)
+	if err != nil {
+		t.Fatalf("count events: %v", err)
+	}
+	if keyframes != 2 || deltas != 18 {
+		t.Errorf("record 1 has %d keyframe and %d delta events, want 2 and 18", keyframes, deltas)
+	}
+
+	for _, stmt := range []string{
+		`UPDATE records SET data = '{"state":"wa"}' WHERE id = 1`,
+		`UPDATE records SET deleted_at = NULL WHERE id = 2`,
+		`DELETE FROM record_index_entries`,
+		`INSERT INTO records (id, data) VALUES (3, '{"state":"ny"}')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	records, repaired, imported, err := s.RebuildProjections(ctx)
+	if err != nil || records != 3 || repaired != 2 || imported != 1 {
+		t.Fatalf("RebuildProjections = %d, %d, %d, %v; want 3 records, 2 repaired, 1 imported", records, repaired, imported, err)
+	}
+
+	record, err := s.GetRecord(ctx, 1)
+	if err != nil || !reflect.DeepEqual(record.Data, want) {
+		t.Errorf("GetRecord(1) = %v, %v; want %v", record.Data, err, want)
+	}
+	if _, err := s.GetRecord(ctx, 2); !errors.Is(err, service.ErrRecordDeleted) {
+		t.Errorf("GetRecord(2) = %v, want ErrRecordDeleted", err)
+	}
+	record, err = s.GetRecord(ctx, 3)
+	if err != nil || record.Data["state"] != "ny" {
+		t.Errorf("GetRecord(3) = %v, %v; want the imported row", record.Data, err)
+	}
+
+	for value, ids := range map[string][]int{"ca": {1}, "ny": {3}, "tx": nil, "wa": nil} {
+		page, err := s.ListRecordsByIndex(ctx, "state", value, service.RecordQuery{})
+		if err != nil {
+			t.Fatalf("ListRecordsByIndex(%s): %v", value, err)
+		}
+		var got []int
+		for _, r := range page.Records {
+			got = append(got, r.ID)
+		}
+		if !reflect.DeepEqual(got, ids) {
+			t.Errorf("ListRecordsByIndex(%s) = %v, want %v", value, got, ids)
+		}
+	}
+
+	// The imported row now has an event, so nothing is left to do
+	records, repaired, imported, err = s.RebuildProjections(ctx)
+	if err != nil || records != 3 || repaired != 0 || imported != 0 {
+		t.Errorf("second RebuildProjections = %d, %d, %d, %v; want 3 records, none repaired or imported", records, repaired, imported, err)
+	}
+}
+
+// TestCompactEvents checks that compaction removes the pruned states from the
+// event log as well, and that the log still rebuilds the current state
+func TestCompactEvents(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := service.NewSQLiteVersionedRecordService(db)
+
+	for _, value := range []string{"first", "second", "third"} {
+		value := value
+		if _, err := s.UpsertRecord(ctx, 1, map[string]*string{"a": &value}); err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+	}
+	value := "x"
+	if _, err := s.UpsertRecord(ctx, 2, map[string]*string{"a": &value}); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if err := s.DeleteRecord(ctx, 2); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+
+	if _, err := s.Compact(ctx, entity.RetentionPolicy{KeepLast: 1}); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	var events, pruned int
+	err := db.QueryRow(`SELECT COUNT(*), COUNT(*) FILTER (WHERE data LIKE '%first%' OR data LIKE '%second%')
+		FROM record_events WHERE record_id = 1`).Scan(&events, &pruned)
+	if err != nil {
+		t.Fatalf("count events: %v", err)
+	}
+	if events != 1 || pruned != 0 {
+		t.Errorf("record 1 has %d events, %d holding pruned states; want 1 and 0", events, pruned)
+	}
+
+	// Later writes are stored against the folded event
+	value = "fourth"
+	if _, err := s.UpsertRecord(ctx, 1, map[string]*string{"b": &value}); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE records SET data = '{}', deleted_at = NULL`); err != nil {
+		t.Fatalf("damage records: %v", err)
+	}
+	if _, repaired, _, err := s.RebuildProjections(ctx); err != nil || repaired != 2 {
+		t.Fatalf("RebuildProjections = %d repaired, %v; want 2", repaired, err)
+	}
+
+	record, err := s.GetRecord(ctx, 1)
+	if want := map[string]string{"a": "third", "b": "fourth"}; err != nil || !reflect.DeepEqual(record.Data, want) {
+		t.Errorf("GetRecord(1) = %v, %v; want %v", record.Data, err, want)
+	}
+	if _, err := s.GetRecord(ctx, 2); !errors.Is(err, service.ErrRecordDeleted) {
+		t.Errorf("GetRecord(2) = %v, want ErrRecordDeleted", err)
+	}
+}
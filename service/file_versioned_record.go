@@ -0,0 +1,89 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rainbowmga/timetravel/segmentlog"
+)
+
+// FileVersionedRecordService implements VersionedRecordService on top of an
+// append-only segment log in a directory, without cgo. Every change is
+// appended to the log before it is applied to an in-memory index of all
+// records, which is rebuilt from the log when the service is opened.
+type FileVersionedRecordService struct {
+	*InMemoryVersionedRecordService
+	log *segmentlog.Log
+}
+
+// NewFileVersionedRecordService opens the log in dir, creating it if needed,
+// and rebuilds the index from it
+func NewFileVersionedRecordService(dir string, opts segmentlog.Options) (*FileVersionedRecordService, error) {
+	s := &FileVersionedRecordService{
+		InMemoryVersionedRecordService: NewInMemoryVersionedRecordService(),
+	}
+
+	l, err := segmentlog.Open(dir, opts, func(entry []byte) error {
+		var c memoryChange
+		if err := json.Unmarshal(entry, &c); err != nil {
+			return fmt.Errorf("failed to unmarshal log entry: %w", err)
+		}
+		return s.apply(c)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log: %w", err)
+	}
+
+	s.log = l
+	s.journal = s.append
+	return s, nil
+}
+
+// append writes a change to the log. The caller must hold the write lock.
+func (s *FileVersionedRecordService) append(c memoryChange) error {
+	entry, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal change: %w", err)
+	}
+	return s.log.Append(entry)
+}
+
+// Discarded returns the number of bytes of an incomplete write that were
+// discarded from the end of the log when it was opened
+func (s *FileVersionedRecordService) Discarded() int64 {
+	return s.log.Discarded()
+}
+
+// NeedsCompaction reports whether enough of the log was written since it was
+// last compacted for CompactLog to be worthwhile
+func (s *FileVersionedRecordService) NeedsCompaction() bool {
+	return s.log.NeedsCompaction()
+}
+
+// CompactLog replaces the log with a snapshot of every record. Writers wait
+// until it is done.
+func (s *FileVersionedRecordService) CompactLog() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.log.Compact(func(add func(entry []byte) error) error {
+		for _, c := range s.changes() {
+			entry, err := json.Marshal(c)
+			if err != nil {
+				return fmt.Errorf("failed to marshal change: %w", err)
+			}
+			if err := add(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the log. Writes made after it is closed fail.
+func (s *FileVersionedRecordService) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.log.Close()
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// versionHash returns the hash sealing a version to the one before it: the
+// SHA-256 of the version's contents and prevHash, the hash of the version
+// before it. The contents are hashed in a canonical JSON form, independent of
+// how the data is stored, so a version can be re-encoded, for example as a
+// keyframe, without breaking the chain.
+func versionHash(v entity.RecordVersion, prevHash string) (string, error) {
+	content := struct {
+		RecordID      int               `json:"record_id"`
+		Version       int               `json:"version"`
+		Data          map[string]string `json:"data"`
+		CreatedAt     string            `json:"created_at"`
+		EffectiveFrom string            `json:"effective_from"`
+		RestoredFrom  int               `json:"restored_from,omitempty"`
+		Deleted       bool              `json:"deleted,omitempty"`
+		Author        string            `json:"author,omitempty"`
+		Reason        string            `json:"reason,omitempty"`
+		Source        string            `json:"source,omitempty"`
+		PrevHash      string            `json:"prev_hash"`
+	}{
+		RecordID:      v.RecordID,
+		Version:       v.Version,
+		Data:          v.Data,
+		CreatedAt:     v.CreatedAt.UTC().Format(time.RFC3339Nano),
+		EffectiveFrom: v.EffectiveFrom.UTC().Format(time.RFC3339Nano),
+		RestoredFrom:  v.RestoredFrom,
+		Deleted:       v.Deleted,
+		Author:        v.Author,
+		Reason:        v.Reason,
+		Source:        v.Source,
+		PrevHash:      prevHash,
+	}
+
+	// Map keys are encoded in sorted order, so the encoding is canonical
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal version for hashing: %w", err)
+	}
+
+	sum := sha256.Sum256(contentJSON)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// chainAnchor is what a record keeps of its own hash chain: the first version
+// that must be sealed and the number and hash of its latest version. A record
+// whose versions were never anchored has none.
+type chainAnchor struct {
+	sealedFrom  int
+	headVersion int
+	headHash    string
+}
+
+// chainVerifier checks the versions of a record, fed in version order,
+// against their hashes and the record's anchor, and stops at the first
+// broken link
+type chainVerifier struct {
+	result   entity.Verification
+	anchor   *chainAnchor
+	first    int
+	next     int
+	prevHash string
+	sealed   bool
+}
+
+// newChainVerifier returns a verifier for a record whose history starts at
+// version first, where prevHash is the hash of the version before it if that
+// version was removed by retention, and anchor is the record's anchor, nil if
+// it has none
+func newChainVerifier(id int, first int, prevHash string, anchor *chainAnchor) *chainVerifier {
+	return &chainVerifier{
+		result:   entity.Verification{ID: id, Valid: true},
+		anchor:   anchor,
+		first:    first,
+		next:     first,
+		prevHash: prevHash,
+		sealed:   prevHash != "",
+	}
+}
+
+// check verifies the next version of the record
+func (c *chainVerifier) check(v entity.RecordVersion) {
+	if !c.result.Valid {
+		return
+	}
+	c.result.Versions++
+
+	if v.Version != c.next {
+		c.fail(c.next, "version is missing")
+		return
+	}
+	c.next++
+
+	// Versions written before hashing was introduced can only precede the
+	// first sealed version
+	if v.Hash == "" {
+		if c.sealed {
+			c.fail(v.Version, "version is not sealed but follows a sealed version")
+			return
+		}
+		if c.anchor != nil && v.Version >= c.anchor.sealedFrom {
+			c.fail(v.Version, "version is not sealed but was written after versions were hashed")
+			return
+		}
+		c.result.Unsealed++
+		return
+	}
+
+	hash, err := versionHash(v, c.prevHash)
+	if err != nil || hash != v.Hash {
+		c.fail(v.Version, "hash does not match the version's contents and the hash of the version before it")
+		return
+	}
+	c.sealed = true
+	c.prevHash = v.Hash
+}
+
+// finish checks that the last version checked is the head of the chain kept
+// by the record, so versions removed from the end of the chain are noticed,
+// and returns the result
+func (c *chainVerifier) finish() entity.Verification {
+	if !c.result.Valid {
+		return c.result
+	}
+
+	last := c.next - 1
+	switch {
+	case c.anchor == nil:
+		if last >= c.first {
+			c.fail(c.first, "record does not keep the head of its chain")
+		}
+	case last < c.anchor.headVersion:
+		c.fail(c.next, "version is missing")
+	case last > c.anchor.headVersion:
+		c.fail(c.anchor.headVersion+1, "version follows the head of the chain kept by the record")
+	case c.prevHash != c.anchor.headHash:
+		c.fail(last, "hash does not match the head of the chain kept by the record")
+	}
+	return c.result
+}
+
+// fail records a broken link at version
+func (c *chainVerifier) fail(version int, reason string) {
+	c.result.Valid = false
+	c.result.Broken = &entity.BrokenLink{
+		Version: version,
+		Reason:  reason,
+	}
+}
@@ -0,0 +1,138 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// TestVerifyDetectsTampering edits the stored history of a record behind the
+// service's back and checks that verification notices
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  []string
+		version int
+	}{
+		{
+			name:    "Intact",
+			tamper:  nil,
+			version: 0,
+		},
+		{
+			name: "HashesBlankedAndDataEdited",
+			tamper: []string{
+				"UPDATE record_versions SET hash = ''",
+				`UPDATE record_versions SET data = '{"name":"forged"}', keyframe = 1 WHERE version = 2`,
+			},
+			version: 1,
+		},
+		{
+			name:    "NewestVersionDeleted",
+			tamper:  []string{"DELETE FROM record_versions WHERE version = 3"},
+			version: 3,
+		},
+		{
+			name:    "EveryVersionDeleted",
+			tamper:  []string{"DELETE FROM record_versions"},
+			version: 1,
+		},
+		{
+			name:    "AnchorRemoved",
+			tamper:  []string{"UPDATE records SET sealed_from = NULL, head_version = NULL, head_hash = NULL"},
+			version: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			s := service.NewSQLiteVersionedRecordService(db)
+
+			for _, name := range []string{"first", "second", "third"} {
+				name := name
+				if _, err := s.UpsertRecord(ctx, 1, map[string]*string{"name": &name}); err != nil {
+					t.Fatalf("UpsertRecord: %v", err)
+				}
+			}
+			for _, statement := range tt.tamper {
+				if _, err := db.Exec(statement); err != nil {
+					t.Fatalf("%s: %v", statement, err)
+				}
+			}
+
+			got, err := s.Verify(ctx, 1)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if tt.version == 0 {
+				if !got.Valid {
+					t.Errorf("Verify = %+v, want valid", got.Broken)
+				}
+			} else if got.Valid || got.Broken.Version != tt.version {
+				t.Errorf("Verify = valid %v, broken %+v; want broken at version %d", got.Valid, got.Broken, tt.version)
+			}
+
+			var all []entity.Verification
+			err = s.VerifyAll(ctx, func(v entity.Verification) error {
+				all = append(all, v)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("VerifyAll: %v", err)
+			}
+			if len(all) != 1 || all[0].Valid != got.Valid {
+				t.Errorf("VerifyAll = %+v, want the result of Verify %+v", all, got)
+			}
+		})
+	}
+}
+
+// TestVerifyAcceptsUnsealedHistory checks that versions written before
+// versions were hashed are accepted before the first sealed version only
+func TestVerifyAcceptsUnsealedHistory(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := service.NewSQLiteVersionedRecordService(db)
+
+	for _, name := range []string{"first", "second"} {
+		name := name
+		if _, err := s.UpsertRecord(ctx, 1, map[string]*string{"name": &name}); err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+	}
+	// Make the record look as if both versions predate hashing, as migration
+	// 12 anchors such a record
+	if _, err := db.Exec("UPDATE record_versions SET hash = ''"); err != nil {
+		t.Fatalf("clear hashes: %v", err)
+	}
+	if _, err := db.Exec("UPDATE records SET sealed_from = 3, head_hash = ''"); err != nil {
+		t.Fatalf("anchor: %v", err)
+	}
+
+	name := "third"
+	if _, err := s.UpsertRecord(ctx, 1, map[string]*string{"name": &name}); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	got, err := s.Verify(ctx, 1)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !got.Valid || got.Unsealed != 2 {
+		t.Errorf("Verify = valid %v, %d unsealed, broken %+v; want valid with 2 unsealed", got.Valid, got.Unsealed, got.Broken)
+	}
+
+	if _, err := db.Exec("UPDATE record_versions SET hash = '' WHERE version = 3"); err != nil {
+		t.Fatalf("clear hash: %v", err)
+	}
+	got, err = s.Verify(ctx, 1)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got.Valid || got.Broken.Version != 3 {
+		t.Errorf("Verify = valid %v, broken %+v; want broken at version 3", got.Valid, got.Broken)
+	}
+}
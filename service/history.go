@@ -0,0 +1,320 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// versionAsOf returns the version that was current at time t: the one in
+// effect at t according to what had been recorded by then. A correction
+// recorded before t changes an earlier interval of valid time, so it is not
+// current even though it is the latest version recorded. versions must be
+// ordered by version ascending. ok is false if the record had no versions at
+// that time.
+func versionAsOf(versions []entity.RecordVersion, t time.Time) (entity.RecordVersion, bool) {
+	return versionAt(versions, t, t)
+}
+
+// versionAt returns the version that, according to what had been recorded by
+// knownAt, was in effect at validAt: among the versions created at or before
+// knownAt, the one with the latest effective time not after validAt. Ties are
+// broken in favour of the most recently recorded version. versions must be
+// ordered by version ascending.
+func versionAt(versions []entity.RecordVersion, validAt, knownAt time.Time) (entity.RecordVersion, bool) {
+	var found entity.RecordVersion
+	ok := false
+	for _, v := range versions {
+		if v.CreatedAt.After(knownAt) || v.EffectiveFrom.After(validAt) {
+			continue
+		}
+		if !ok || !v.EffectiveFrom.Before(found.EffectiveFrom) {
+			found = v
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// timeline returns the versions that make up a record's valid-time history as
+// currently known, ordered by effective time. Where several versions share an
+// effective time only the most recently recorded one is kept, since it
+// supersedes the others. versions must be ordered by version ascending.
+func timeline(versions []entity.RecordVersion) []entity.RecordVersion {
+	var entries []entity.RecordVersion
+	for _, v := range versions {
+		replaced := false
+		for i, e := range entries {
+			if e.EffectiveFrom.Equal(v.EffectiveFrom) {
+				entries[i] = v
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			entries = append(entries, v)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].EffectiveFrom.Before(entries[j].EffectiveFrom)
+	})
+	return entries
+}
+
+// correctionStep is one interval of valid time changed by a correction
+type correctionStep struct {
+	from     time.Time
+	to       *time.Time
+	replaces int
+	old      map[string]string
+	new      map[string]string
+}
+
+// planCorrection works out which intervals of a record's timeline change when
+// updates are applied retroactively from time at.
+//
+// The state in effect at at is updated and becomes effective from at. Each
+// updated key then carries forward through the later intervals until the
+// first one that changed that key itself, since from there on the later
+// change takes precedence over the correction. Intervals whose data ends up
+// unchanged are left out, as are intervals over which the record was deleted:
+// a correction never brings a deleted record back, and a key carries past a
+// deletion unless the record came back with a different value for it.
+func planCorrection(entries []entity.RecordVersion, at time.Time, updates map[string]*string) []correctionStep {
+	// find the interval in effect at the time of the correction
+	k := -1
+	for i, e := range entries {
+		if e.EffectiveFrom.After(at) {
+			break
+		}
+		k = i
+	}
+
+	base := map[string]string{}
+	replaces := 0
+	if k >= 0 {
+		base = entries[k].Data
+		replaces = entries[k].Version
+	}
+
+	// Within a deletion the state to carry keys from is the last live one
+	deleted := k >= 0 && entries[k].Deleted
+	if deleted {
+		base = map[string]string{}
+		for i := k - 1; i >= 0; i-- {
+			if !entries[i].Deleted {
+				base = entries[i].Data
+				break
+			}
+		}
+	}
+
+	var steps []correctionStep
+	corrected := applyUpdates(base, updates)
+	if !deleted && !dataEqual(base, corrected) {
+		steps = append(steps, correctionStep{
+			from:     at,
+			to:       intervalEnd(entries, k),
+			replaces: replaces,
+			old:      base,
+			new:      corrected,
+		})
+	}
+
+	active := map[string]*string{}
+	for key, value := range updates {
+		active[key] = value
+	}
+
+	previous := base
+	for j := k + 1; j < len(entries) && len(active) > 0; j++ {
+		if entries[j].Deleted {
+			continue
+		}
+
+		original := entries[j].Data
+		for key := range active {
+			before, hadBefore := previous[key]
+			after, hasAfter := original[key]
+			if hadBefore != hasAfter || before != after {
+				delete(active, key)
+			}
+		}
+
+		next := applyUpdates(original, active)
+		if !dataEqual(original, next) {
+			steps = append(steps, correctionStep{
+				from:     entries[j].EffectiveFrom,
+				to:       intervalEnd(entries, j),
+				replaces: entries[j].Version,
+				old:      original,
+				new:      next,
+			})
+		}
+		previous = original
+	}
+
+	return steps
+}
+
+// intervalEnd returns the effective time of the entry following entries[i],
+// or nil if it is the last one
+func intervalEnd(entries []entity.RecordVersion, i int) *time.Time {
+	if i+1 >= len(entries) {
+		return nil
+	}
+	end := entries[i+1].EffectiveFrom
+	return &end
+}
+
+// applyUpdates returns a copy of data with updates applied; a nil update
+// deletes the key
+func applyUpdates(data map[string]string, updates map[string]*string) map[string]string {
+	result := make(map[string]string, len(data))
+	for key, value := range data {
+		result[key] = value
+	}
+	for key, value := range updates {
+		if value == nil {
+			delete(result, key)
+		} else {
+			result[key] = *value
+		}
+	}
+	return result
+}
+
+// dataEqual reports whether two records hold the same keys and values
+func dataEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// diffData returns the change of every key whose value differs between old
+// and new
+func diffData(old, new map[string]string) map[string]entity.ValueChange {
+	changes := map[string]entity.ValueChange{}
+	for key, value := range old {
+		value := value
+		if other, ok := new[key]; !ok {
+			changes[key] = entity.ValueChange{Old: &value}
+		} else if other != value {
+			other := other
+			changes[key] = entity.ValueChange{Old: &value, New: &other}
+		}
+	}
+	for key, value := range new {
+		value := value
+		if _, ok := old[key]; !ok {
+			changes[key] = entity.ValueChange{New: &value}
+		}
+	}
+	return changes
+}
+
+// diffVersions describes the changes between the data of two versions
+func diffVersions(id, from, to int, old, new map[string]string) entity.RecordDiff {
+	diff := entity.RecordDiff{
+		ID:      id,
+		From:    from,
+		To:      to,
+		Added:   map[string]string{},
+		Removed: map[string]string{},
+		Changed: map[string]entity.ValueChange{},
+	}
+
+	for key, change := range diffData(old, new) {
+		switch {
+		case change.Old == nil:
+			diff.Added[key] = *change.New
+		case change.New == nil:
+			diff.Removed[key] = *change.Old
+		default:
+			diff.Changed[key] = change
+		}
+	}
+
+	return diff
+}
+
+// fieldHistory returns the versions in which key was added, changed or
+// removed, in version order. versions must be ordered by version ascending.
+func fieldHistory(versions []entity.RecordVersion, key string) []entity.FieldChange {
+	history := []entity.FieldChange{}
+
+	var previous *string
+	for _, v := range versions {
+		var current *string
+		if value, ok := v.Data[key]; ok {
+			current = &value
+		}
+
+		var change string
+		switch {
+		case previous == nil && current != nil:
+			change = entity.FieldAdded
+		case previous != nil && current == nil:
+			change = entity.FieldRemoved
+		case previous != nil && *previous != *current:
+			change = entity.FieldChanged
+		}
+
+		if change != "" {
+			history = append(history, entity.FieldChange{
+				Version:       v.Version,
+				CreatedAt:     v.CreatedAt,
+				EffectiveFrom: v.EffectiveFrom,
+				Change:        change,
+				Old:           previous,
+				New:           current,
+			})
+		}
+		previous = current
+	}
+
+	return history
+}
+
+// timelineEntries returns the valid-time intervals of a record's history as
+// recorded by knownAt. versions must be ordered by version ascending.
+func timelineEntries(versions []entity.RecordVersion, knownAt time.Time) []entity.TimelineEntry {
+	var known []entity.RecordVersion
+	for _, v := range versions {
+		if !v.CreatedAt.After(knownAt) {
+			known = append(known, v)
+		}
+	}
+
+	entries := timeline(known)
+	result := make([]entity.TimelineEntry, 0, len(entries))
+	for i, e := range entries {
+		result = append(result, entity.TimelineEntry{
+			VersionInfo: versionInfo(e),
+			ValidFrom:   e.EffectiveFrom,
+			ValidTo:     intervalEnd(entries, i),
+		})
+	}
+	return result
+}
+
+// versionInfo returns the metadata of a version
+func versionInfo(v entity.RecordVersion) entity.VersionInfo {
+	return entity.VersionInfo{
+		Version:        v.Version,
+		CreatedAt:      v.CreatedAt,
+		EffectiveFrom:  v.EffectiveFrom,
+		RestoredFrom:   v.RestoredFrom,
+		Deleted:        v.Deleted,
+		Hash:           v.Hash,
+		ChangeMetadata: v.ChangeMetadata,
+	}
+}
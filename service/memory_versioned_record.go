@@ -0,0 +1,906 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// InMemoryVersionedRecordService is an in-memory implementation of
+// VersionedRecordService. It is safe for concurrent use.
+type InMemoryVersionedRecordService struct {
+	mu      sync.RWMutex
+	records map[int]*memoryRecord
+	nextID  int
+	// journal, if set, is given every change before it is applied. A change
+	// it fails to record is not applied.
+	journal func(memoryChange) error
+}
+
+// memoryRecord is the stored state and history of a single record
+type memoryRecord struct {
+	// current is the last live state if the record was deleted
+	current  map[string]string
+	deleted  bool
+	versions []entity.RecordVersion
+	tags     map[string]int
+}
+
+// memoryChange is a write to a single record. Every write is described by a
+// change and applied with apply, so a change is all there is to know about it.
+type memoryChange struct {
+	RecordID int `json:"record_id"`
+	// Versions are appended to the record's history in order
+	Versions []entity.RecordVersion `json:"versions,omitempty"`
+	// Current replaces the record's current state unless it is nil, which
+	// also brings back a deleted record
+	Current map[string]string `json:"current"`
+	// Deleted marks the record deleted, keeping its current state
+	Deleted bool `json:"deleted,omitempty"`
+	// Tag is pointed at TagVersion unless it is empty
+	Tag        string `json:"tag,omitempty"`
+	TagVersion int    `json:"tag_version,omitempty"`
+}
+
+// NewInMemoryVersionedRecordService creates a new InMemoryVersionedRecordService instance
+func NewInMemoryVersionedRecordService() *InMemoryVersionedRecordService {
+	return &InMemoryVersionedRecordService{
+		records: map[int]*memoryRecord{},
+	}
+}
+
+// apply makes a change to the stored records. Versions without an ID are
+// given the next one. The caller must hold the write lock.
+func (s *InMemoryVersionedRecordService) apply(c memoryChange) error {
+	nextID := s.nextID
+	versions := make([]entity.RecordVersion, len(c.Versions))
+	for i, v := range c.Versions {
+		if v.ID == 0 {
+			nextID++
+			v.ID = nextID
+		} else if v.ID > nextID {
+			nextID = v.ID
+		}
+		versions[i] = v
+	}
+	c.Versions = versions
+
+	if s.journal != nil {
+		if err := s.journal(c); err != nil {
+			return err
+		}
+	}
+
+	r := s.records[c.RecordID]
+	if r == nil {
+		r = &memoryRecord{tags: map[string]int{}}
+		s.records[c.RecordID] = r
+	}
+
+	s.nextID = nextID
+	r.versions = append(r.versions, c.Versions...)
+	if c.Current != nil {
+		r.current = c.Current
+		r.deleted = false
+	}
+	if c.Deleted {
+		r.deleted = true
+	}
+	if c.Tag != "" {
+		r.tags[c.Tag] = c.TagVersion
+	}
+	return nil
+}
+
+// changes describes the stored records as the changes that recreate them, in
+// ID order. The caller must hold the lock.
+func (s *InMemoryVersionedRecordService) changes() []memoryChange {
+	ids := make([]int, 0, len(s.records))
+	for id := range s.records {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var changes []memoryChange
+	for _, id := range ids {
+		r := s.records[id]
+		changes = append(changes, memoryChange{
+			RecordID: id,
+			Versions: r.versions,
+			Current:  r.current,
+			Deleted:  r.deleted,
+		})
+
+		tags := make([]string, 0, len(r.tags))
+		for tag := range r.tags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			changes = append(changes, memoryChange{
+				RecordID:   id,
+				Tag:        tag,
+				TagVersion: r.tags[tag],
+			})
+		}
+	}
+	return changes
+}
+
+// newVersion returns the next version of a record holding data. The caller
+// must hold the write lock.
+func (s *InMemoryVersionedRecordService) newVersion(ctx context.Context, id int, data map[string]string, createdAt, effective time.Time) entity.RecordVersion {
+	version := 1
+	if r := s.records[id]; r != nil {
+		version = len(r.versions) + 1
+	}
+
+	return entity.RecordVersion{
+		RecordID:       id,
+		Version:        version,
+		Data:           data,
+		CreatedAt:      createdAt,
+		EffectiveFrom:  effective,
+		ChangeMetadata: changeMetadata(ctx),
+	}
+}
+
+// lookup returns a record, or ErrRecordDoesNotExist. The caller must hold the lock.
+func (s *InMemoryVersionedRecordService) lookup(id int) (*memoryRecord, error) {
+	if id <= 0 {
+		return nil, ErrRecordIDInvalid
+	}
+
+	r := s.records[id]
+	if r == nil {
+		return nil, ErrRecordDoesNotExist
+	}
+	return r, nil
+}
+
+// live returns a record that was not deleted, or ErrRecordDoesNotExist or
+// ErrRecordDeleted. The caller must hold the lock.
+func (s *InMemoryVersionedRecordService) live(id int) (*memoryRecord, error) {
+	r, err := s.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	if r.deleted {
+		return nil, ErrRecordDeleted
+	}
+	return r, nil
+}
+
+// precedes reports whether effective is before the latest effective version
+// of the record, so a new version may not be effective at effective
+func (r *memoryRecord) precedes(effective time.Time) bool {
+	for _, v := range r.versions {
+		if effective.Before(v.EffectiveFrom) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRecord retrieves the latest version of a record
+func (s *InMemoryVersionedRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, err := s.live(id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: applyUpdates(r.current, nil),
+	}, nil
+}
+
+// CreateRecord inserts a new record and creates its first version
+func (s *InMemoryVersionedRecordService) CreateRecord(ctx context.Context, record entity.Record) error {
+	if record.ID <= 0 {
+		return ErrRecordIDInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return ErrEffectiveTimeInFuture
+	}
+
+	if r := s.records[record.ID]; r != nil && !r.deleted {
+		return ErrRecordAlreadyExists
+	}
+
+	return s.create(ctx, record.ID, applyUpdates(record.Data, nil), now, effective)
+}
+
+// create records the creation of a record that does not exist yet, or was
+// deleted. The caller must hold the write lock.
+func (s *InMemoryVersionedRecordService) create(ctx context.Context, id int, data map[string]string, now, effective time.Time) error {
+	if r := s.records[id]; r != nil && r.precedes(effective) {
+		return ErrEffectiveTimeConflict
+	}
+
+	return s.apply(memoryChange{
+		RecordID: id,
+		Versions: []entity.RecordVersion{s.newVersion(ctx, id, data, now, effective)},
+		Current:  data,
+	})
+}
+
+// UpdateRecord updates a record and creates a new version
+func (s *InMemoryVersionedRecordService) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return entity.Record{}, ErrEffectiveTimeInFuture
+	}
+
+	return s.update(ctx, id, updates, now, effective)
+}
+
+// update applies updates to the current state of a record as a new version.
+// The caller must hold the write lock.
+func (s *InMemoryVersionedRecordService) update(ctx context.Context, id int, updates map[string]*string, now, effective time.Time) (entity.Record, error) {
+	r, err := s.live(id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	// The new version may not be effective before the latest effective version
+	if r.precedes(effective) {
+		return entity.Record{}, ErrEffectiveTimeConflict
+	}
+
+	data := applyUpdates(r.current, updates)
+	err = s.apply(memoryChange{
+		RecordID: id,
+		Versions: []entity.RecordVersion{s.newVersion(ctx, id, data, now, effective)},
+		Current:  data,
+	})
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: applyUpdates(data, nil),
+	}, nil
+}
+
+// UpsertRecord applies updates to a record, creating it if it does not exist
+// or was deleted
+func (s *InMemoryVersionedRecordService) UpsertRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	record, _, err := s.UpsertRecordWithVersion(ctx, id, updates)
+	return record, err
+}
+
+// UpsertRecordWithVersion applies updates to a record, creating it if it does
+// not exist or was deleted, and returns the number of the version it appended
+func (s *InMemoryVersionedRecordService) UpsertRecordWithVersion(ctx context.Context, id int, updates map[string]*string) (entity.Record, int, error) {
+	if id <= 0 {
+		return entity.Record{}, 0, ErrRecordIDInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return entity.Record{}, 0, ErrEffectiveTimeInFuture
+	}
+
+	if r := s.records[id]; r != nil && !r.deleted {
+		record, err := s.update(ctx, id, updates, now, effective)
+		if err != nil {
+			return entity.Record{}, 0, err
+		}
+		return record, len(s.records[id].versions), nil
+	}
+
+	// Create new record - exclude null values
+	data := applyUpdates(nil, updates)
+	if err := s.create(ctx, id, data, now, effective); err != nil {
+		return entity.Record{}, 0, err
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: applyUpdates(data, nil),
+	}, len(s.records[id].versions), nil
+}
+
+// GetRecordWithVersion retrieves the current state of a record and the number
+// of its latest version
+func (s *InMemoryVersionedRecordService) GetRecordWithVersion(ctx context.Context, id int) (entity.Record, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, err := s.live(id)
+	if err != nil {
+		return entity.Record{}, 0, err
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: applyUpdates(r.current, nil),
+	}, len(r.versions), nil
+}
+
+// UpdateRecordIfVersion updates a record and creates a new version if its
+// latest version is still one of versions
+func (s *InMemoryVersionedRecordService) UpdateRecordIfVersion(ctx context.Context, id int, versions []int, updates map[string]*string) (entity.Record, int, error) {
+	if id <= 0 {
+		return entity.Record{}, 0, ErrRecordIDInvalid
+	}
+	if err := validExpectedVersions(versions); err != nil {
+		return entity.Record{}, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return entity.Record{}, 0, ErrEffectiveTimeInFuture
+	}
+
+	r, err := s.lookup(id)
+	if err != nil {
+		return entity.Record{}, 0, err
+	}
+	if current := len(r.versions); !versionMatches(versions, current) {
+		return entity.Record{}, 0, &VersionConflictError{Expected: versions, Current: current}
+	}
+
+	record, err := s.update(ctx, id, updates, now, effective)
+	if err != nil {
+		return entity.Record{}, 0, err
+	}
+	return record, len(r.versions), nil
+}
+
+// CreateOrUpdateRecord creates a new record or updates an existing one, preserving history
+func (s *InMemoryVersionedRecordService) CreateOrUpdateRecord(ctx context.Context, record entity.Record) (entity.Record, error) {
+	// Merge the record's values into any existing record
+	updates := make(map[string]*string)
+	for key, value := range record.Data {
+		val := value
+		updates[key] = &val
+	}
+
+	return s.UpsertRecord(ctx, record.ID, updates)
+}
+
+// version returns a version of a record. The caller must hold the lock.
+func (s *InMemoryVersionedRecordService) version(id int, version int) (entity.RecordVersion, error) {
+	if id <= 0 {
+		return entity.RecordVersion{}, ErrRecordIDInvalid
+	}
+	if version <= 0 {
+		return entity.RecordVersion{}, ErrInvalidVersion
+	}
+
+	r := s.records[id]
+	if r == nil || version > len(r.versions) {
+		return entity.RecordVersion{}, ErrVersionDoesNotExist
+	}
+	return r.versions[version-1], nil
+}
+
+// GetRecordVersion retrieves a record at a specific version
+func (s *InMemoryVersionedRecordService) GetRecordVersion(ctx context.Context, id int, version int) (entity.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, err := s.version(id, version)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: applyUpdates(v.Data, nil),
+	}, nil
+}
+
+// DiffVersions compares versions a and b of a record
+func (s *InMemoryVersionedRecordService) DiffVersions(ctx context.Context, id int, a, b int) (entity.RecordDiff, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	from, err := s.version(id, a)
+	if err != nil {
+		return entity.RecordDiff{}, err
+	}
+
+	to, err := s.version(id, b)
+	if err != nil {
+		return entity.RecordDiff{}, err
+	}
+
+	return diffVersions(id, a, b, from.Data, to.Data), nil
+}
+
+// GetRecordAsOf retrieves the version of a record that was current at time t
+func (s *InMemoryVersionedRecordService) GetRecordAsOf(ctx context.Context, id int, t time.Time) (entity.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, err := s.lookup(id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	version, ok := versionAsOf(r.versions, t)
+	if !ok {
+		return entity.Record{}, ErrRecordDoesNotExist
+	}
+	if version.Deleted {
+		return entity.Record{}, ErrRecordDeleted
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: applyUpdates(version.Data, nil),
+	}, nil
+}
+
+// GetRecordAt retrieves the version of a record in effect at validAt as known at knownAt
+func (s *InMemoryVersionedRecordService) GetRecordAt(ctx context.Context, id int, validAt, knownAt time.Time) (entity.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, err := s.lookup(id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	version, ok := versionAt(r.versions, validAt, knownAt)
+	if !ok {
+		return entity.Record{}, ErrRecordDoesNotExist
+	}
+	if version.Deleted {
+		return entity.Record{}, ErrRecordDeleted
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: applyUpdates(version.Data, nil),
+	}, nil
+}
+
+// RestoreVersion appends a new version holding exactly the data of an earlier version
+func (s *InMemoryVersionedRecordService) RestoreVersion(ctx context.Context, id int, version int) (entity.Record, error) {
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+	if version <= 0 {
+		return entity.Record{}, ErrInvalidVersion
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return entity.Record{}, ErrEffectiveTimeInFuture
+	}
+
+	r, err := s.live(id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	old, err := s.version(id, version)
+	if err != nil {
+		return entity.Record{}, err
+	}
+	if old.Deleted {
+		return entity.Record{}, ErrVersionDeleted
+	}
+	if r.precedes(effective) {
+		return entity.Record{}, ErrEffectiveTimeConflict
+	}
+
+	restored := s.newVersion(ctx, id, old.Data, now, effective)
+	restored.RestoredFrom = version
+	err = s.apply(memoryChange{
+		RecordID: id,
+		Versions: []entity.RecordVersion{restored},
+		Current:  old.Data,
+	})
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: applyUpdates(old.Data, nil),
+	}, nil
+}
+
+// DeleteRecord appends a tombstone version to a record and marks it deleted
+func (s *InMemoryVersionedRecordService) DeleteRecord(ctx context.Context, id int) error {
+	if id <= 0 {
+		return ErrRecordIDInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return ErrEffectiveTimeInFuture
+	}
+
+	r, err := s.live(id)
+	if err != nil {
+		return err
+	}
+	if r.precedes(effective) {
+		return ErrEffectiveTimeConflict
+	}
+
+	tombstone := s.newVersion(ctx, id, map[string]string{}, now, effective)
+	tombstone.Deleted = true
+	return s.apply(memoryChange{
+		RecordID: id,
+		Versions: []entity.RecordVersion{tombstone},
+		Deleted:  true,
+	})
+}
+
+// UndeleteRecord appends a new version holding the state a deleted record had
+// when it was deleted and makes it current again
+func (s *InMemoryVersionedRecordService) UndeleteRecord(ctx context.Context, id int) (entity.Record, error) {
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return entity.Record{}, ErrEffectiveTimeInFuture
+	}
+
+	r, err := s.lookup(id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+	if !r.deleted {
+		return entity.Record{}, ErrRecordNotDeleted
+	}
+	if r.precedes(effective) {
+		return entity.Record{}, ErrEffectiveTimeConflict
+	}
+
+	data := r.current
+	err = s.apply(memoryChange{
+		RecordID: id,
+		Versions: []entity.RecordVersion{s.newVersion(ctx, id, data, now, effective)},
+		Current:  data,
+	})
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: applyUpdates(data, nil),
+	}, nil
+}
+
+// GetFieldHistory returns every change made to a single key of a record
+func (s *InMemoryVersionedRecordService) GetFieldHistory(ctx context.Context, id int, key string) ([]entity.FieldChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, err := s.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return fieldHistory(r.versions, key), nil
+}
+
+// ListRecords returns a page of the records that are not deleted, in ID order
+func (s *InMemoryVersionedRecordService) ListRecords(ctx context.Context, q RecordQuery) (entity.RecordPage, error) {
+	after, limit, err := pageBounds(q)
+	if err != nil {
+		return entity.RecordPage{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int, 0, len(s.records))
+	for id := range s.records {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	records := []entity.Record{}
+	for _, id := range ids {
+		r := s.records[id]
+
+		// The state listed, and when it was written
+		data, deleted, updatedAt := r.current, r.deleted, r.updatedAt()
+		if !q.AsOf.IsZero() {
+			v, ok := versionAsOf(r.versions, q.AsOf)
+			if !ok {
+				continue
+			}
+			data, deleted, updatedAt = v.Data, v.Deleted, v.CreatedAt
+		}
+
+		if deleted || updatedAt.Before(q.UpdatedSince) || !matchesAll(q.Filters, data) {
+			continue
+		}
+		records = append(records, entity.Record{ID: id, Data: applyUpdates(data, nil)})
+		if len(records) > limit {
+			break
+		}
+	}
+	return newPage(records, limit), nil
+}
+
+// updatedAt returns the time the record was last written
+func (r *memoryRecord) updatedAt() time.Time {
+	if len(r.versions) == 0 {
+		return time.Time{}
+	}
+	return r.versions[len(r.versions)-1].CreatedAt
+}
+
+// Snapshot calls fn with every record as it was at time t, in ID order
+func (s *InMemoryVersionedRecordService) Snapshot(ctx context.Context, t time.Time, fn func(entity.Record) error) error {
+	// Collect the snapshot first so that fn runs without holding the lock
+	s.mu.RLock()
+	var records []entity.Record
+	for id, r := range s.records {
+		if v, ok := versionAsOf(r.versions, t); ok && !v.Deleted {
+			records = append(records, entity.Record{ID: id, Data: applyUpdates(v.Data, nil)})
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TagVersion names a version of a record
+func (s *InMemoryVersionedRecordService) TagVersion(ctx context.Context, id int, version int, tag string) error {
+	return s.setTag(id, version, tag, false)
+}
+
+// MoveTag points an existing tag at a different version
+func (s *InMemoryVersionedRecordService) MoveTag(ctx context.Context, id int, version int, tag string) error {
+	return s.setTag(id, version, tag, true)
+}
+
+// setTag creates a tag, or moves an existing one if move is set
+func (s *InMemoryVersionedRecordService) setTag(id int, version int, tag string, move bool) error {
+	if id <= 0 {
+		return ErrRecordIDInvalid
+	}
+	if version <= 0 {
+		return ErrInvalidVersion
+	}
+	if !validTag.MatchString(tag) {
+		return ErrTagInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.version(id, version); err != nil {
+		return err
+	}
+
+	current := s.records[id].tags[tag]
+	switch {
+	case current == version:
+		return nil
+	case current == 0 && move:
+		return ErrTagDoesNotExist
+	case current != 0 && !move:
+		return ErrTagAlreadyExists
+	}
+
+	return s.apply(memoryChange{
+		RecordID:   id,
+		Tag:        tag,
+		TagVersion: version,
+	})
+}
+
+// GetRecordByTag retrieves a record at the version a tag points at
+func (s *InMemoryVersionedRecordService) GetRecordByTag(ctx context.Context, id int, tag string) (entity.Record, error) {
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var version int
+	if r := s.records[id]; r != nil {
+		version = r.tags[tag]
+	}
+	if version == 0 {
+		return entity.Record{}, ErrTagDoesNotExist
+	}
+
+	v, err := s.version(id, version)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: applyUpdates(v.Data, nil),
+	}, nil
+}
+
+// tagsByVersion returns the tags of a record grouped by the version they
+// point at, each group in name order
+func (r *memoryRecord) tagsByVersion() map[int][]string {
+	tags := map[int][]string{}
+	for tag, version := range r.tags {
+		tags[version] = append(tags[version], tag)
+	}
+	for _, names := range tags {
+		sort.Strings(names)
+	}
+	return tags
+}
+
+// ListVersions returns all versions for a record, ordered by version descending
+func (s *InMemoryVersionedRecordService) ListVersions(ctx context.Context, id int) ([]entity.VersionInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, err := s.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := r.tagsByVersion()
+	versions := make([]entity.VersionInfo, 0, len(r.versions))
+	for i := len(r.versions) - 1; i >= 0; i-- {
+		info := versionInfo(r.versions[i])
+		info.Tags = tags[info.Version]
+		versions = append(versions, info)
+	}
+
+	return versions, nil
+}
+
+// GetVersionInfo returns the metadata of a single version of a record
+func (s *InMemoryVersionedRecordService) GetVersionInfo(ctx context.Context, id int, version int) (entity.VersionInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, err := s.version(id, version)
+	if err != nil {
+		return entity.VersionInfo{}, err
+	}
+
+	info := versionInfo(v)
+	info.Tags = s.records[id].tagsByVersion()[version]
+	return info, nil
+}
+
+// GetTimeline returns the valid-time intervals of a record's history
+func (s *InMemoryVersionedRecordService) GetTimeline(ctx context.Context, id int, knownAt time.Time) ([]entity.TimelineEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, err := s.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := r.tagsByVersion()
+	entries := timelineEntries(r.versions, knownAt)
+	for i := range entries {
+		entries[i].Tags = tags[entries[i].Version]
+	}
+
+	return entries, nil
+}
+
+// CorrectRecord applies updates retroactively from effectiveFrom, appending a
+// version for every interval of the record's timeline the correction changes
+func (s *InMemoryVersionedRecordService) CorrectRecord(ctx context.Context, id int, effectiveFrom time.Time, updates map[string]*string) (entity.CorrectionReport, error) {
+	if id <= 0 {
+		return entity.CorrectionReport{}, ErrRecordIDInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if effectiveFrom.After(now) {
+		return entity.CorrectionReport{}, ErrEffectiveTimeInFuture
+	}
+
+	r, err := s.live(id)
+	if err != nil {
+		return entity.CorrectionReport{}, err
+	}
+
+	report := entity.CorrectionReport{
+		ID:            id,
+		EffectiveFrom: effectiveFrom,
+		Intervals:     []entity.CorrectedInterval{},
+	}
+
+	change := memoryChange{RecordID: id}
+	next := len(r.versions) + 1
+	for _, step := range planCorrection(timeline(r.versions), effectiveFrom, updates) {
+		change.Versions = append(change.Versions, entity.RecordVersion{
+			RecordID:       id,
+			Version:        next,
+			Data:           step.new,
+			CreatedAt:      now,
+			EffectiveFrom:  step.from,
+			ChangeMetadata: changeMetadata(ctx),
+		})
+
+		// The last interval is open-ended, so correcting it changes the current state
+		if step.to == nil {
+			change.Current = step.new
+		}
+
+		report.Intervals = append(report.Intervals, entity.CorrectedInterval{
+			Version:   next,
+			Replaces:  step.replaces,
+			ValidFrom: step.from,
+			ValidTo:   step.to,
+			Changes:   diffData(step.old, step.new),
+		})
+		next++
+	}
+
+	if len(change.Versions) > 0 {
+		if err := s.apply(change); err != nil {
+			return entity.CorrectionReport{}, err
+		}
+	}
+	return report, nil
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"errors"
+	"sync"
 
 	"github.com/rainbowmga/timetravel/entity"
 )
@@ -30,17 +31,26 @@ type RecordService interface {
 }
 
 // InMemoryRecordService is an in-memory implementation of RecordService.
+// It is safe for concurrent use.
 type InMemoryRecordService struct {
+	mu   sync.RWMutex
 	data map[int]entity.Record
 }
 
-func NewInMemoryRecordService() InMemoryRecordService {
-	return InMemoryRecordService{
+func NewInMemoryRecordService() *InMemoryRecordService {
+	return &InMemoryRecordService{
 		data: map[int]entity.Record{},
 	}
 }
 
 func (s *InMemoryRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	record := s.data[id]
 	if record.ID == 0 {
 		return entity.Record{}, ErrRecordDoesNotExist
@@ -56,16 +66,26 @@ func (s *InMemoryRecordService) CreateRecord(ctx context.Context, record entity.
 		return ErrRecordIDInvalid
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	existingRecord := s.data[id]
 	if existingRecord.ID != 0 {
 		return ErrRecordAlreadyExists
 	}
 
-	s.data[id] = record
+	s.data[id] = record.Copy() // copy so the caller's map can't change the stored record
 	return nil
 }
 
 func (s *InMemoryRecordService) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	entry := s.data[id]
 	if entry.ID == 0 {
 		return entity.Record{}, ErrRecordDoesNotExist
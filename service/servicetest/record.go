@@ -0,0 +1,156 @@
+// Package servicetest is a conformance suite for implementations of
+// service.RecordService and service.VersionedRecordService.
+//
+// Every implementation is expected to pass it from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		servicetest.TestVersionedRecordService(t, func(t *testing.T) service.VersionedRecordService {
+//			return service.NewInMemoryVersionedRecordService()
+//		})
+//	}
+//
+// The factory is called once per test case and must return an empty service.
+package servicetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// RecordServiceFactory returns a new, empty RecordService
+type RecordServiceFactory func(t *testing.T) service.RecordService
+
+// TestRecordService runs the conformance suite for RecordService
+func TestRecordService(t *testing.T, newService RecordServiceFactory) {
+	ctx := context.Background()
+
+	t.Run("CreateAndGet", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1", "b": "2"}})
+
+		got, err := s.GetRecord(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetRecord: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1", "b": "2"})
+	})
+
+	t.Run("CreateExisting", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1"}})
+
+		err := s.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"a": "2"}})
+		wantErr(t, err, service.ErrRecordAlreadyExists)
+
+		got, err := s.GetRecord(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetRecord: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1"})
+	})
+
+	t.Run("InvalidID", func(t *testing.T) {
+		s := newService(t)
+		for _, id := range []int{0, -1} {
+			wantErr(t, s.CreateRecord(ctx, entity.Record{ID: id, Data: map[string]string{}}), service.ErrRecordIDInvalid)
+
+			_, err := s.GetRecord(ctx, id)
+			wantErr(t, err, service.ErrRecordIDInvalid)
+
+			_, err = s.UpdateRecord(ctx, id, map[string]*string{"a": str("1")})
+			wantErr(t, err, service.ErrRecordIDInvalid)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		s := newService(t)
+
+		_, err := s.GetRecord(ctx, 1)
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+
+		_, err = s.UpdateRecord(ctx, 1, map[string]*string{"a": str("1")})
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+	})
+
+	t.Run("UpdateSetsAndDeletes", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1", "b": "2"}})
+
+		got, err := s.UpdateRecord(ctx, 1, map[string]*string{"a": str("10"), "b": nil, "c": str("3"), "missing": nil})
+		if err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+		want := map[string]string{"a": "10", "c": "3"}
+		wantRecord(t, got, 1, want)
+
+		got, err = s.GetRecord(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetRecord: %v", err)
+		}
+		wantRecord(t, got, 1, want)
+	})
+
+	t.Run("ReturnedRecordsAreCopies", func(t *testing.T) {
+		s := newService(t)
+		created := entity.Record{ID: 1, Data: map[string]string{"a": "1"}}
+		mustCreate(t, s, created)
+		created.Data["a"] = "changed"
+
+		got, err := s.GetRecord(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetRecord: %v", err)
+		}
+		got.Data["a"] = "changed"
+
+		updated, err := s.UpdateRecord(ctx, 1, map[string]*string{"b": str("2")})
+		if err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+		updated.Data["b"] = "changed"
+
+		got, err = s.GetRecord(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetRecord: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1", "b": "2"})
+	})
+}
+
+// mustCreate creates a record or fails the test
+func mustCreate(t *testing.T, s service.RecordService, record entity.Record) {
+	t.Helper()
+	if err := s.CreateRecord(context.Background(), record); err != nil {
+		t.Fatalf("CreateRecord(%d): %v", record.ID, err)
+	}
+}
+
+// wantRecord fails the test unless record has the given id and data
+func wantRecord(t *testing.T, record entity.Record, id int, data map[string]string) {
+	t.Helper()
+	if record.ID != id {
+		t.Errorf("record id = %d, want %d", record.ID, id)
+	}
+	if len(record.Data) != 0 || len(data) != 0 {
+		if !reflect.DeepEqual(record.Data, data) {
+			t.Errorf("record %d data = %v, want %v", id, record.Data, data)
+		}
+	}
+}
+
+// wantErr fails the test unless err is target
+func wantErr(t *testing.T, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Errorf("error = %v, want %v", err, target)
+	}
+}
+
+// str returns a pointer to s, for building updates
+func str(s string) *string {
+	return &s
+}
@@ -0,0 +1,979 @@
+package servicetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// VersionedRecordServiceFactory returns a new, empty VersionedRecordService
+type VersionedRecordServiceFactory func(t *testing.T) service.VersionedRecordService
+
+// TestVersionedRecordService runs the conformance suite for
+// VersionedRecordService, including the RecordService suite
+func TestVersionedRecordService(t *testing.T, newService VersionedRecordServiceFactory) {
+	ctx := context.Background()
+
+	t.Run("RecordService", func(t *testing.T) {
+		TestRecordService(t, func(t *testing.T) service.RecordService {
+			return newService(t)
+		})
+	})
+
+	t.Run("VersionNumbering", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1"}})
+		mustUpdate(t, s, 1, map[string]*string{"a": str("2")})
+		mustUpdate(t, s, 1, map[string]*string{"b": str("3")})
+
+		wantVersions(t, s, 1, 3, 2, 1)
+		wantVersion(t, s, 1, 1, map[string]string{"a": "1"})
+		wantVersion(t, s, 1, 2, map[string]string{"a": "2"})
+		wantVersion(t, s, 1, 3, map[string]string{"a": "2", "b": "3"})
+
+		_, err := s.GetRecordVersion(ctx, 1, 0)
+		wantErr(t, err, service.ErrInvalidVersion)
+		_, err = s.GetRecordVersion(ctx, 1, 4)
+		wantErr(t, err, service.ErrVersionDoesNotExist)
+		_, err = s.GetRecordVersion(ctx, 2, 1)
+		wantErr(t, err, service.ErrVersionDoesNotExist)
+		_, err = s.GetVersionInfo(ctx, 1, 4)
+		wantErr(t, err, service.ErrVersionDoesNotExist)
+		_, err = s.ListVersions(ctx, 2)
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+	})
+
+	t.Run("NullDeletesAreVersioned", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1", "b": "2"}})
+		mustUpdate(t, s, 1, map[string]*string{"b": nil})
+
+		wantVersion(t, s, 1, 1, map[string]string{"a": "1", "b": "2"})
+		wantVersion(t, s, 1, 2, map[string]string{"a": "1"})
+	})
+
+	t.Run("UpsertRecord", func(t *testing.T) {
+		s := newService(t)
+
+		got, err := s.UpsertRecord(ctx, 1, map[string]*string{"a": str("1"), "b": nil})
+		if err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1"})
+
+		got, err = s.UpsertRecord(ctx, 1, map[string]*string{"a": nil, "c": str("3")})
+		if err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"c": "3"})
+
+		wantVersions(t, s, 1, 2, 1)
+		wantVersion(t, s, 1, 1, map[string]string{"a": "1"})
+
+		_, err = s.UpsertRecord(ctx, 0, map[string]*string{"a": str("1")})
+		wantErr(t, err, service.ErrRecordIDInvalid)
+	})
+
+	t.Run("CreateOrUpdateRecordMerges", func(t *testing.T) {
+		s := newService(t)
+
+		got, err := s.CreateOrUpdateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"a": "1", "b": "2"}})
+		if err != nil {
+			t.Fatalf("CreateOrUpdateRecord: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1", "b": "2"})
+
+		got, err = s.CreateOrUpdateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"b": "3"}})
+		if err != nil {
+			t.Fatalf("CreateOrUpdateRecord: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1", "b": "3"})
+		wantVersions(t, s, 1, 2, 1)
+	})
+
+	t.Run("ConcurrentUpserts", func(t *testing.T) {
+		s := newService(t)
+		const writers = 50
+
+		var wg sync.WaitGroup
+		errs := make(chan error, writers)
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := fmt.Sprintf("key%d", i)
+				if _, err := s.UpsertRecord(ctx, 1, map[string]*string{key: str("value")}); err != nil {
+					errs <- err
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("UpsertRecord: %v", err)
+		}
+
+		got, err := s.GetRecord(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetRecord: %v", err)
+		}
+		if len(got.Data) != writers {
+			t.Errorf("record has %d keys, want %d; updates were lost", len(got.Data), writers)
+		}
+
+		versions, err := s.ListVersions(ctx, 1)
+		if err != nil {
+			t.Fatalf("ListVersions: %v", err)
+		}
+		if len(versions) != writers {
+			t.Fatalf("record has %d versions, want %d", len(versions), writers)
+		}
+		for i, v := range versions {
+			if v.Version != writers-i {
+				t.Fatalf("versions[%d] = %d, want %d", i, v.Version, writers-i)
+			}
+		}
+	})
+
+	t.Run("UpsertRecordWithVersion", func(t *testing.T) {
+		s := newService(t)
+
+		for want := 1; want <= 2; want++ {
+			_, version, err := s.UpsertRecordWithVersion(ctx, 1, map[string]*string{"a": str(fmt.Sprint(want))})
+			if err != nil {
+				t.Fatalf("UpsertRecordWithVersion: %v", err)
+			}
+			if version != want {
+				t.Errorf("version = %d, want %d", version, want)
+			}
+		}
+
+		if err := s.DeleteRecord(ctx, 1); err != nil {
+			t.Fatalf("DeleteRecord: %v", err)
+		}
+		got, version, err := s.UpsertRecordWithVersion(ctx, 1, map[string]*string{"b": str("1")})
+		if err != nil {
+			t.Fatalf("UpsertRecordWithVersion: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"b": "1"})
+		if version != 4 {
+			t.Errorf("version after recreating = %d, want 4", version)
+		}
+	})
+
+	t.Run("UpdateRecordIfVersion", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1"}})
+
+		got, version, err := s.GetRecordWithVersion(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetRecordWithVersion: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1"})
+		if version != 1 {
+			t.Errorf("version = %d, want 1", version)
+		}
+
+		got, version, err = s.UpdateRecordIfVersion(ctx, 1, []int{1}, map[string]*string{"a": str("2")})
+		if err != nil {
+			t.Fatalf("UpdateRecordIfVersion: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "2"})
+		if version != 2 {
+			t.Errorf("version = %d, want 2", version)
+		}
+
+		// A stale version is rejected with the latest one, writing nothing
+		_, _, err = s.UpdateRecordIfVersion(ctx, 1, []int{1}, map[string]*string{"a": str("3")})
+		wantErr(t, err, service.ErrVersionConflict)
+		var conflict *service.VersionConflictError
+		if !errors.As(err, &conflict) || len(conflict.Expected) != 1 || conflict.Expected[0] != 1 || conflict.Current != 2 {
+			t.Errorf("error = %v, want a conflict between versions 1 and 2", err)
+		}
+		_, _, err = s.UpdateRecordIfVersion(ctx, 1, nil, map[string]*string{"a": str("3")})
+		wantErr(t, err, service.ErrVersionConflict)
+		wantVersions(t, s, 1, 2, 1)
+
+		// Any of several versions matches, and AnyVersion matches every one
+		_, version, err = s.UpdateRecordIfVersion(ctx, 1, []int{1, 2}, map[string]*string{"a": str("3")})
+		if err != nil || version != 3 {
+			t.Fatalf("UpdateRecordIfVersion(1, 2) = %d, %v; want version 3", version, err)
+		}
+		_, version, err = s.UpdateRecordIfVersion(ctx, 1, []int{service.AnyVersion}, map[string]*string{"a": str("4")})
+		if err != nil || version != 4 {
+			t.Fatalf("UpdateRecordIfVersion(AnyVersion) = %d, %v; want version 4", version, err)
+		}
+
+		_, _, err = s.UpdateRecordIfVersion(ctx, 2, []int{1}, map[string]*string{"a": str("1")})
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+		_, _, err = s.UpdateRecordIfVersion(ctx, 2, []int{service.AnyVersion}, map[string]*string{"a": str("1")})
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+		_, _, err = s.UpdateRecordIfVersion(ctx, 1, []int{0}, map[string]*string{"a": str("1")})
+		wantErr(t, err, service.ErrInvalidVersion)
+
+		if err := s.DeleteRecord(ctx, 1); err != nil {
+			t.Fatalf("DeleteRecord: %v", err)
+		}
+		_, _, err = s.GetRecordWithVersion(ctx, 1)
+		wantErr(t, err, service.ErrRecordDeleted)
+		_, _, err = s.UpdateRecordIfVersion(ctx, 1, []int{service.AnyVersion}, map[string]*string{"a": str("5")})
+		wantErr(t, err, service.ErrRecordDeleted)
+	})
+
+	t.Run("ConcurrentUpdatesIfVersion", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{}})
+		const writers = 20
+
+		// Every writer read version 1, so only one of them may write
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		written := 0
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := fmt.Sprintf("key%d", i)
+				_, _, err := s.UpdateRecordIfVersion(ctx, 1, []int{1}, map[string]*string{key: str("value")})
+				if err != nil && !errors.Is(err, service.ErrVersionConflict) {
+					t.Errorf("UpdateRecordIfVersion: %v", err)
+				}
+				if err == nil {
+					mu.Lock()
+					written++
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if written != 1 {
+			t.Errorf("%d writers updated version 1, want 1", written)
+		}
+		wantVersions(t, s, 1, 2, 1)
+	})
+
+	t.Run("DiffVersions", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1", "b": "2"}})
+		mustUpdate(t, s, 1, map[string]*string{"a": str("10"), "b": nil, "c": str("3")})
+
+		diff, err := s.DiffVersions(ctx, 1, 1, 2)
+		if err != nil {
+			t.Fatalf("DiffVersions: %v", err)
+		}
+		if diff.From != 1 || diff.To != 2 {
+			t.Errorf("diff between %d and %d, want 1 and 2", diff.From, diff.To)
+		}
+		if !reflect.DeepEqual(diff.Added, map[string]string{"c": "3"}) {
+			t.Errorf("added = %v", diff.Added)
+		}
+		if !reflect.DeepEqual(diff.Removed, map[string]string{"b": "2"}) {
+			t.Errorf("removed = %v", diff.Removed)
+		}
+		if change, ok := diff.Changed["a"]; len(diff.Changed) != 1 || !ok || *change.Old != "1" || *change.New != "10" {
+			t.Errorf("changed = %v", diff.Changed)
+		}
+
+		_, err = s.DiffVersions(ctx, 1, 1, 3)
+		wantErr(t, err, service.ErrVersionDoesNotExist)
+	})
+
+	t.Run("RestoreVersion", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1"}})
+		mustUpdate(t, s, 1, map[string]*string{"a": str("2"), "b": str("3")})
+
+		got, err := s.RestoreVersion(ctx, 1, 1)
+		if err != nil {
+			t.Fatalf("RestoreVersion: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1"})
+		wantVersion(t, s, 1, 3, map[string]string{"a": "1"})
+
+		info, err := s.GetVersionInfo(ctx, 1, 3)
+		if err != nil {
+			t.Fatalf("GetVersionInfo: %v", err)
+		}
+		if info.RestoredFrom != 1 {
+			t.Errorf("restored from %d, want 1", info.RestoredFrom)
+		}
+
+		_, err = s.RestoreVersion(ctx, 1, 4)
+		wantErr(t, err, service.ErrVersionDoesNotExist)
+		_, err = s.RestoreVersion(ctx, 2, 1)
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+
+		// A restore is effective from the time the context carries, which
+		// must not precede the latest effective version
+		_, err = s.RestoreVersion(service.WithEffectiveFrom(ctx, time.Now().Add(time.Hour)), 1, 2)
+		wantErr(t, err, service.ErrEffectiveTimeInFuture)
+		_, err = s.RestoreVersion(service.WithEffectiveFrom(ctx, info.EffectiveFrom.Add(-time.Second)), 1, 2)
+		wantErr(t, err, service.ErrEffectiveTimeConflict)
+
+		// Sharing the latest effective time is allowed
+		effective := info.EffectiveFrom
+		if _, err := s.RestoreVersion(service.WithEffectiveFrom(ctx, effective), 1, 2); err != nil {
+			t.Fatalf("RestoreVersion: %v", err)
+		}
+		info, err = s.GetVersionInfo(ctx, 1, 4)
+		if err != nil {
+			t.Fatalf("GetVersionInfo: %v", err)
+		}
+		if info.RestoredFrom != 2 || !info.EffectiveFrom.Equal(effective) {
+			t.Errorf("version 4 restored from %d effective from %v, want 2 from %v", info.RestoredFrom, info.EffectiveFrom, effective)
+		}
+	})
+
+	t.Run("SoftDelete", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1"}})
+		mustUpdate(t, s, 1, map[string]*string{"b": str("2")})
+		beforeDelete := time.Now()
+
+		_, err := s.UndeleteRecord(ctx, 1)
+		wantErr(t, err, service.ErrRecordNotDeleted)
+
+		if err := s.DeleteRecord(ctx, 1); err != nil {
+			t.Fatalf("DeleteRecord: %v", err)
+		}
+		afterDelete := time.Now()
+
+		_, err = s.GetRecord(ctx, 1)
+		wantErr(t, err, service.ErrRecordDeleted)
+		_, err = s.GetRecordAsOf(ctx, 1, afterDelete)
+		wantErr(t, err, service.ErrRecordDeleted)
+		_, err = s.UpdateRecord(ctx, 1, map[string]*string{"a": str("x")})
+		wantErr(t, err, service.ErrRecordDeleted)
+		_, err = s.RestoreVersion(ctx, 1, 1)
+		wantErr(t, err, service.ErrRecordDeleted)
+		err = s.DeleteRecord(ctx, 1)
+		wantErr(t, err, service.ErrRecordDeleted)
+		err = s.DeleteRecord(ctx, 2)
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+
+		got, err := s.GetRecordAsOf(ctx, 1, beforeDelete)
+		if err != nil {
+			t.Fatalf("GetRecordAsOf: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1", "b": "2"})
+
+		wantVersions(t, s, 1, 3, 2, 1)
+		wantVersion(t, s, 1, 3, map[string]string{})
+		info, err := s.GetVersionInfo(ctx, 1, 3)
+		if err != nil {
+			t.Fatalf("GetVersionInfo: %v", err)
+		}
+		if !info.Deleted {
+			t.Errorf("version 3 is not a tombstone: %+v", info)
+		}
+
+		var records []entity.Record
+		err = s.Snapshot(ctx, afterDelete, func(record entity.Record) error {
+			records = append(records, record)
+			return nil
+		})
+		if err != nil || len(records) != 0 {
+			t.Errorf("Snapshot after delete = %v, %v; want no records", records, err)
+		}
+
+		got, err = s.UndeleteRecord(ctx, 1)
+		if err != nil {
+			t.Fatalf("UndeleteRecord: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1", "b": "2"})
+		wantVersion(t, s, 1, 4, map[string]string{"a": "1", "b": "2"})
+
+		_, err = s.RestoreVersion(ctx, 1, 3)
+		wantErr(t, err, service.ErrVersionDeleted)
+
+		// Writing a deleted record brings it back with only the data written
+		if err := s.DeleteRecord(ctx, 1); err != nil {
+			t.Fatalf("DeleteRecord: %v", err)
+		}
+		if err := s.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"c": "3"}}); err != nil {
+			t.Fatalf("CreateRecord: %v", err)
+		}
+		got, err = s.GetRecord(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetRecord: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"c": "3"})
+		wantVersions(t, s, 1, 6, 5, 4, 3, 2, 1)
+	})
+
+	t.Run("FieldHistory", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1"}})
+		mustUpdate(t, s, 1, map[string]*string{"b": str("1")})
+		mustUpdate(t, s, 1, map[string]*string{"a": str("2")})
+		mustUpdate(t, s, 1, map[string]*string{"a": nil})
+
+		history, err := s.GetFieldHistory(ctx, 1, "a")
+		if err != nil {
+			t.Fatalf("GetFieldHistory: %v", err)
+		}
+		want := []struct {
+			version int
+			change  string
+		}{{1, entity.FieldAdded}, {3, entity.FieldChanged}, {4, entity.FieldRemoved}}
+		if len(history) != len(want) {
+			t.Fatalf("history has %d changes, want %d", len(history), len(want))
+		}
+		for i, w := range want {
+			if history[i].Version != w.version || history[i].Change != w.change {
+				t.Errorf("history[%d] = version %d %s, want version %d %s", i, history[i].Version, history[i].Change, w.version, w.change)
+			}
+		}
+
+		_, err = s.GetFieldHistory(ctx, 2, "a")
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1"}})
+		mustUpdate(t, s, 1, map[string]*string{"a": str("2")})
+
+		if err := s.TagVersion(ctx, 1, 1, "bound"); err != nil {
+			t.Fatalf("TagVersion: %v", err)
+		}
+		if err := s.TagVersion(ctx, 1, 1, "bound"); err != nil {
+			t.Errorf("tagging the same version again: %v", err)
+		}
+		wantErr(t, s.TagVersion(ctx, 1, 2, "bound"), service.ErrTagAlreadyExists)
+		wantErr(t, s.TagVersion(ctx, 1, 1, "-bad"), service.ErrTagInvalid)
+		wantErr(t, s.TagVersion(ctx, 1, 3, "other"), service.ErrVersionDoesNotExist)
+		wantErr(t, s.MoveTag(ctx, 1, 2, "missing"), service.ErrTagDoesNotExist)
+
+		got, err := s.GetRecordByTag(ctx, 1, "bound")
+		if err != nil {
+			t.Fatalf("GetRecordByTag: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1"})
+
+		if err := s.MoveTag(ctx, 1, 2, "bound"); err != nil {
+			t.Fatalf("MoveTag: %v", err)
+		}
+		got, err = s.GetRecordByTag(ctx, 1, "bound")
+		if err != nil {
+			t.Fatalf("GetRecordByTag: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "2"})
+
+		versions, err := s.ListVersions(ctx, 1)
+		if err != nil {
+			t.Fatalf("ListVersions: %v", err)
+		}
+		if !reflect.DeepEqual(versions[0].Tags, []string{"bound"}) || len(versions[1].Tags) != 0 {
+			t.Errorf("tags = %v, %v; want [bound] on version 2 only", versions[0].Tags, versions[1].Tags)
+		}
+
+		_, err = s.GetRecordByTag(ctx, 1, "missing")
+		wantErr(t, err, service.ErrTagDoesNotExist)
+	})
+
+	t.Run("ChangeMetadata", func(t *testing.T) {
+		s := newService(t)
+		metadata := entity.ChangeMetadata{Author: "alice", Reason: "renewal", Source: "crm"}
+		if err := s.CreateRecord(service.WithChangeMetadata(ctx, metadata), entity.Record{ID: 1, Data: map[string]string{"a": "1"}}); err != nil {
+			t.Fatalf("CreateRecord: %v", err)
+		}
+		mustUpdate(t, s, 1, map[string]*string{"a": str("2")})
+
+		versions, err := s.ListVersions(ctx, 1)
+		if err != nil {
+			t.Fatalf("ListVersions: %v", err)
+		}
+		if versions[1].ChangeMetadata != metadata {
+			t.Errorf("version 1 metadata = %+v, want %+v", versions[1].ChangeMetadata, metadata)
+		}
+		if versions[0].ChangeMetadata != (entity.ChangeMetadata{}) {
+			t.Errorf("version 2 metadata = %+v, want none", versions[0].ChangeMetadata)
+		}
+
+		info, err := s.GetVersionInfo(ctx, 1, 1)
+		if err != nil {
+			t.Fatalf("GetVersionInfo: %v", err)
+		}
+		if info.ChangeMetadata != metadata {
+			t.Errorf("GetVersionInfo metadata = %+v, want %+v", info.ChangeMetadata, metadata)
+		}
+	})
+
+	t.Run("GetRecordAsOf", func(t *testing.T) {
+		s := newService(t)
+		before := time.Now()
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1"}})
+		between := time.Now()
+		mustUpdate(t, s, 1, map[string]*string{"a": str("2")})
+
+		_, err := s.GetRecordAsOf(ctx, 1, before)
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+
+		got, err := s.GetRecordAsOf(ctx, 1, between)
+		if err != nil {
+			t.Fatalf("GetRecordAsOf: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1"})
+
+		got, err = s.GetRecordAsOf(ctx, 1, time.Now())
+		if err != nil {
+			t.Fatalf("GetRecordAsOf: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "2"})
+
+		_, err = s.GetRecordAsOf(ctx, 2, time.Now())
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+	})
+
+	t.Run("EffectiveTime", func(t *testing.T) {
+		s := newService(t)
+		base := time.Now().Add(-24 * time.Hour)
+
+		err := s.CreateRecord(service.WithEffectiveFrom(ctx, time.Now().Add(time.Hour)), entity.Record{ID: 1, Data: map[string]string{}})
+		wantErr(t, err, service.ErrEffectiveTimeInFuture)
+
+		if err := s.CreateRecord(service.WithEffectiveFrom(ctx, base), entity.Record{ID: 1, Data: map[string]string{"a": "1"}}); err != nil {
+			t.Fatalf("CreateRecord: %v", err)
+		}
+		if _, err := s.UpdateRecord(service.WithEffectiveFrom(ctx, base.Add(2*time.Hour)), 1, map[string]*string{"a": str("2")}); err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+		_, err = s.UpdateRecord(service.WithEffectiveFrom(ctx, base.Add(time.Hour)), 1, map[string]*string{"a": str("3")})
+		wantErr(t, err, service.ErrEffectiveTimeConflict)
+
+		now := time.Now()
+		for _, c := range []struct {
+			validAt time.Time
+			want    map[string]string
+		}{
+			{base.Add(time.Hour), map[string]string{"a": "1"}},
+			{base.Add(3 * time.Hour), map[string]string{"a": "2"}},
+		} {
+			got, err := s.GetRecordAt(ctx, 1, c.validAt, now)
+			if err != nil {
+				t.Fatalf("GetRecordAt: %v", err)
+			}
+			wantRecord(t, got, 1, c.want)
+		}
+
+		_, err = s.GetRecordAt(ctx, 1, base.Add(-time.Hour), now)
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+	})
+
+	t.Run("CorrectRecord", func(t *testing.T) {
+		s := newService(t)
+		base := time.Now().Add(-24 * time.Hour)
+		if err := s.CreateRecord(service.WithEffectiveFrom(ctx, base), entity.Record{ID: 1, Data: map[string]string{"a": "1", "b": "1"}}); err != nil {
+			t.Fatalf("CreateRecord: %v", err)
+		}
+		if _, err := s.UpdateRecord(service.WithEffectiveFrom(ctx, base.Add(2*time.Hour)), 1, map[string]*string{"b": str("2")}); err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+		beforeCorrection := time.Now()
+
+		// a carries forward into the current interval, b does not since it
+		// was changed there
+		report, err := s.CorrectRecord(ctx, 1, base.Add(time.Hour), map[string]*string{"a": str("x"), "b": str("x")})
+		if err != nil {
+			t.Fatalf("CorrectRecord: %v", err)
+		}
+		if len(report.Intervals) != 2 {
+			t.Fatalf("report has %d intervals, want 2", len(report.Intervals))
+		}
+		first, second := report.Intervals[0], report.Intervals[1]
+		if first.Version != 3 || first.Replaces != 1 || first.ValidTo == nil || len(first.Changes) != 2 {
+			t.Errorf("first interval = %+v", first)
+		}
+		if second.Version != 4 || second.Replaces != 2 || second.ValidTo != nil || len(second.Changes) != 1 {
+			t.Errorf("second interval = %+v", second)
+		}
+
+		got, err := s.GetRecord(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetRecord: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "x", "b": "2"})
+
+		now := time.Now()
+		got, err = s.GetRecordAt(ctx, 1, base.Add(90*time.Minute), now)
+		if err != nil {
+			t.Fatalf("GetRecordAt: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "x", "b": "x"})
+
+		got, err = s.GetRecordAt(ctx, 1, base.Add(90*time.Minute), beforeCorrection)
+		if err != nil {
+			t.Fatalf("GetRecordAt: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "1", "b": "1"})
+
+		timeline, err := s.GetTimeline(ctx, 1, now)
+		if err != nil {
+			t.Fatalf("GetTimeline: %v", err)
+		}
+		var versions []int
+		for _, e := range timeline {
+			versions = append(versions, e.Version)
+		}
+		if !reflect.DeepEqual(versions, []int{1, 3, 4}) {
+			t.Errorf("timeline versions = %v, want [1 3 4]", versions)
+		}
+		if timeline[2].ValidTo != nil || timeline[0].ValidTo == nil || !timeline[0].ValidTo.Equal(timeline[1].ValidFrom) {
+			t.Errorf("timeline intervals do not line up: %+v", timeline)
+		}
+
+		_, err = s.CorrectRecord(ctx, 2, base, map[string]*string{"a": str("x")})
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+		_, err = s.CorrectRecord(ctx, 1, time.Now().Add(time.Hour), map[string]*string{"a": str("x")})
+		wantErr(t, err, service.ErrEffectiveTimeInFuture)
+	})
+
+	t.Run("Timeline", func(t *testing.T) {
+		s := newService(t)
+		base := time.Now().Add(-24 * time.Hour)
+		beforeCreation := time.Now()
+		if err := s.CreateRecord(service.WithEffectiveFrom(ctx, base), entity.Record{ID: 1, Data: map[string]string{"a": "1"}}); err != nil {
+			t.Fatalf("CreateRecord: %v", err)
+		}
+		if _, err := s.UpdateRecord(service.WithEffectiveFrom(ctx, base.Add(2*time.Hour)), 1, map[string]*string{"a": str("2")}); err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+		twoVersions := time.Now()
+		if _, err := s.UpdateRecord(service.WithEffectiveFrom(ctx, base.Add(4*time.Hour)), 1, map[string]*string{"a": str("3")}); err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+		beforeCorrection := time.Now()
+
+		// The correction supersedes version 2 over its whole interval and
+		// stops where version 3 changed a itself
+		if _, err := s.CorrectRecord(ctx, 1, base.Add(2*time.Hour), map[string]*string{"a": str("x")}); err != nil {
+			t.Fatalf("CorrectRecord: %v", err)
+		}
+
+		starts := []time.Time{base, base.Add(2 * time.Hour), base.Add(4 * time.Hour)}
+		wantTimeline(t, s, 1, time.Now(), []int{1, 4, 3}, starts)
+		wantTimeline(t, s, 1, beforeCorrection, []int{1, 2, 3}, starts)
+		wantTimeline(t, s, 1, twoVersions, []int{1, 2}, starts[:2])
+		wantTimeline(t, s, 1, beforeCreation, nil, nil)
+
+		_, err := s.GetTimeline(ctx, 2, time.Now())
+		wantErr(t, err, service.ErrRecordDoesNotExist)
+	})
+
+	t.Run("ListRecords", func(t *testing.T) {
+		s := newService(t)
+		for _, id := range []int{5, 3, 1, 4, 2} {
+			mustCreate(t, s, entity.Record{ID: id, Data: map[string]string{"id": fmt.Sprint(id)}})
+		}
+		if err := s.DeleteRecord(ctx, 4); err != nil {
+			t.Fatalf("DeleteRecord: %v", err)
+		}
+
+		var ids []int
+		q := service.RecordQuery{Limit: 2}
+		for pages := 0; ; pages++ {
+			if pages > 3 {
+				t.Fatalf("listing did not end after %d pages", pages)
+			}
+			page, err := s.ListRecords(ctx, q)
+			if err != nil {
+				t.Fatalf("ListRecords: %v", err)
+			}
+			for _, record := range page.Records {
+				ids = append(ids, record.ID)
+				wantRecord(t, record, record.ID, map[string]string{"id": fmt.Sprint(record.ID)})
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+		if !reflect.DeepEqual(ids, []int{1, 2, 3, 5}) {
+			t.Errorf("listed records %v, want [1 2 3 5]", ids)
+		}
+
+		// Backends may compare update times to the millisecond only
+		time.Sleep(2 * time.Millisecond)
+		since := time.Now()
+		mustUpdate(t, s, 3, map[string]*string{"b": str("1")})
+		page, err := s.ListRecords(ctx, service.RecordQuery{UpdatedSince: since})
+		if err != nil {
+			t.Fatalf("ListRecords: %v", err)
+		}
+		if len(page.Records) != 1 || page.Records[0].ID != 3 || page.NextCursor != "" {
+			t.Errorf("records updated since = %+v, want only record 3", page)
+		}
+
+		_, err = s.ListRecords(ctx, service.RecordQuery{Cursor: "not a cursor"})
+		wantErr(t, err, service.ErrCursorInvalid)
+		_, err = s.ListRecords(ctx, service.RecordQuery{Limit: service.MaxPageSize + 1})
+		wantErr(t, err, service.ErrPageSizeInvalid)
+	})
+
+	t.Run("FilterRecords", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"state": "CA", "industry": "construction"}})
+		mustCreate(t, s, entity.Record{ID: 2, Data: map[string]string{"state": "CA", "industry": "retail"}})
+		mustCreate(t, s, entity.Record{ID: 3, Data: map[string]string{"state": "NY", "industry": "construction", "closed": "yes"}})
+		mustCreate(t, s, entity.Record{ID: 4, Data: map[string]string{"industry": "Construction"}})
+		before := time.Now()
+		mustUpdate(t, s, 2, map[string]*string{"industry": str("construction")})
+
+		for _, c := range []struct {
+			name    string
+			filters []service.Filter
+			asOf    time.Time
+			want    []int
+		}{
+			{"equal", []service.Filter{{Key: "state", Op: service.FilterEqual, Value: "CA"}, {Key: "industry", Op: service.FilterEqual, Value: "construction"}}, time.Time{}, []int{1, 2}},
+			{"equal as of", []service.Filter{{Key: "state", Op: service.FilterEqual, Value: "CA"}, {Key: "industry", Op: service.FilterEqual, Value: "construction"}}, before, []int{1}},
+			{"not equal", []service.Filter{{Key: "state", Op: service.FilterNotEqual, Value: "CA"}}, time.Time{}, []int{3, 4}},
+			{"prefix", []service.Filter{{Key: "industry", Op: service.FilterPrefix, Value: "con"}}, time.Time{}, []int{1, 2, 3}},
+			{"prefix as of", []service.Filter{{Key: "industry", Op: service.FilterPrefix, Value: "con"}}, before, []int{1, 3}},
+			{"exists", []service.Filter{{Key: "closed", Op: service.FilterExists}}, time.Time{}, []int{3}},
+			{"missing", []service.Filter{{Key: "state", Op: service.FilterMissing}}, time.Time{}, []int{4}},
+			{"none", nil, time.Time{}, []int{1, 2, 3, 4}},
+		} {
+			page, err := s.ListRecords(ctx, service.RecordQuery{Filters: c.filters, AsOf: c.asOf})
+			if err != nil {
+				t.Fatalf("%s: ListRecords: %v", c.name, err)
+			}
+			var ids []int
+			for _, record := range page.Records {
+				ids = append(ids, record.ID)
+			}
+			if !reflect.DeepEqual(ids, c.want) {
+				t.Errorf("%s: listed records %v, want %v", c.name, ids, c.want)
+			}
+		}
+
+		page, err := s.ListRecords(ctx, service.RecordQuery{AsOf: before, Limit: 1})
+		if err != nil {
+			t.Fatalf("ListRecords: %v", err)
+		}
+		if len(page.Records) != 1 || page.NextCursor == "" {
+			t.Fatalf("first page as of = %+v, want one record and a cursor", page)
+		}
+		wantRecord(t, page.Records[0], 1, map[string]string{"state": "CA", "industry": "construction"})
+		page, err = s.ListRecords(ctx, service.RecordQuery{AsOf: before, Limit: 1, Cursor: page.NextCursor})
+		if err != nil {
+			t.Fatalf("ListRecords: %v", err)
+		}
+		if len(page.Records) != 1 {
+			t.Fatalf("second page as of = %+v, want one record", page)
+		}
+		wantRecord(t, page.Records[0], 2, map[string]string{"state": "CA", "industry": "retail"})
+
+		_, err = s.ListRecords(ctx, service.RecordQuery{Filters: []service.Filter{{Key: "a", Op: "like"}}})
+		wantErr(t, err, service.ErrFilterInvalid)
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		s := newService(t)
+		mustCreate(t, s, entity.Record{ID: 2, Data: map[string]string{"a": "1"}})
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1"}})
+		at := time.Now()
+		mustUpdate(t, s, 1, map[string]*string{"a": str("2")})
+		mustCreate(t, s, entity.Record{ID: 3, Data: map[string]string{"a": "1"}})
+
+		var records []entity.Record
+		err := s.Snapshot(ctx, at, func(record entity.Record) error {
+			records = append(records, record)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Snapshot: %v", err)
+		}
+		if len(records) != 2 {
+			t.Fatalf("snapshot has %d records, want 2", len(records))
+		}
+		wantRecord(t, records[0], 1, map[string]string{"a": "1"})
+		wantRecord(t, records[1], 2, map[string]string{"a": "1"})
+
+		stop := fmt.Errorf("stop")
+		calls := 0
+		err = s.Snapshot(ctx, time.Now(), func(record entity.Record) error {
+			calls++
+			return stop
+		})
+		if err != stop || calls != 1 {
+			t.Errorf("Snapshot returned %v after %d calls, want %v after 1", err, calls, stop)
+		}
+	})
+
+	t.Run("AsOfAfterCorrection", func(t *testing.T) {
+		s := newService(t)
+		base := time.Now().Add(-24 * time.Hour)
+		if err := s.CreateRecord(service.WithEffectiveFrom(ctx, base), entity.Record{ID: 1, Data: map[string]string{"a": "1"}}); err != nil {
+			t.Fatalf("CreateRecord: %v", err)
+		}
+		if _, err := s.UpdateRecord(service.WithEffectiveFrom(ctx, base.Add(2*time.Hour)), 1, map[string]*string{"a": str("2")}); err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+		mustCreate(t, s, entity.Record{ID: 2, Data: map[string]string{"a": "5"}})
+
+		// The correction is the latest version recorded, but it changes an
+		// interval that is no longer current
+		if _, err := s.CorrectRecord(ctx, 1, base.Add(time.Hour), map[string]*string{"a": str("5")}); err != nil {
+			t.Fatalf("CorrectRecord: %v", err)
+		}
+		now := time.Now()
+
+		got, err := s.GetRecordAsOf(ctx, 1, now)
+		if err != nil {
+			t.Fatalf("GetRecordAsOf: %v", err)
+		}
+		wantRecord(t, got, 1, map[string]string{"a": "2"})
+
+		var records []entity.Record
+		err = s.Snapshot(ctx, now, func(record entity.Record) error {
+			records = append(records, record)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Snapshot: %v", err)
+		}
+		if len(records) != 2 {
+			t.Fatalf("snapshot has %d records, want 2", len(records))
+		}
+		wantRecord(t, records[0], 1, map[string]string{"a": "2"})
+
+		for _, c := range []struct {
+			value string
+			want  []int
+		}{
+			{"2", []int{1}},
+			{"5", []int{2}},
+		} {
+			page, err := s.ListRecords(ctx, service.RecordQuery{
+				AsOf:    now,
+				Filters: []service.Filter{{Key: "a", Op: service.FilterEqual, Value: c.value}},
+			})
+			if err != nil {
+				t.Fatalf("ListRecords: %v", err)
+			}
+			var ids []int
+			for _, record := range page.Records {
+				ids = append(ids, record.ID)
+			}
+			if !reflect.DeepEqual(ids, c.want) {
+				t.Errorf("records with a=%s as of now = %v, want %v", c.value, ids, c.want)
+			}
+		}
+	})
+
+	t.Run("AsOfAfterCompaction", func(t *testing.T) {
+		s := newService(t)
+		retention, ok := s.(service.RetentionService)
+		if !ok {
+			t.Skip("service does not prune history")
+		}
+		mustCreate(t, s, entity.Record{ID: 1, Data: map[string]string{"a": "1"}})
+		time.Sleep(5 * time.Millisecond)
+		during := time.Now()
+		time.Sleep(5 * time.Millisecond)
+		mustUpdate(t, s, 1, map[string]*string{"a": str("2")})
+		mustUpdate(t, s, 1, map[string]*string{"a": str("3")})
+		mustCreate(t, s, entity.Record{ID: 2, Data: map[string]string{"a": "1"}})
+
+		if _, err := retention.Compact(ctx, entity.RetentionPolicy{KeepLast: 1}); err != nil {
+			t.Fatalf("Compact: %v", err)
+		}
+
+		// Record 1 existed at the time, so leaving it out would be wrong
+		err := s.Snapshot(ctx, during, func(record entity.Record) error {
+			t.Errorf("snapshot streamed record %d", record.ID)
+			return nil
+		})
+		wantErr(t, err, service.ErrVersionCompacted)
+		_, err = s.ListRecords(ctx, service.RecordQuery{AsOf: during})
+		wantErr(t, err, service.ErrVersionCompacted)
+
+		// Now every record has its current version
+		now := time.Now()
+		var records []entity.Record
+		err = s.Snapshot(ctx, now, func(record entity.Record) error {
+			records = append(records, record)
+			return nil
+		})
+		if err != nil || len(records) != 2 {
+			t.Fatalf("Snapshot(now) = %d records, %v; want 2", len(records), err)
+		}
+		wantRecord(t, records[0], 1, map[string]string{"a": "3"})
+		page, err := s.ListRecords(ctx, service.RecordQuery{AsOf: now})
+		if err != nil || len(page.Records) != 2 {
+			t.Fatalf("ListRecords(as of now) = %+v, %v; want 2 records", page, err)
+		}
+	})
+}
+
+// wantTimeline checks that the timeline of a record as recorded by knownAt
+// holds the given versions, in effect over consecutive intervals starting at
+// starts, with the last one open-ended
+func wantTimeline(t *testing.T, s service.VersionedRecordService, id int, knownAt time.Time, versions []int, starts []time.Time) {
+	t.Helper()
+	timeline, err := s.GetTimeline(context.Background(), id, knownAt)
+	if err != nil {
+		t.Fatalf("GetTimeline(%d): %v", id, err)
+	}
+	if len(timeline) != len(versions) {
+		t.Fatalf("timeline known at %v has %d entries, want versions %v: %+v", knownAt, len(timeline), versions, timeline)
+	}
+	for i, e := range timeline {
+		if e.Version != versions[i] || !e.ValidFrom.Equal(starts[i]) {
+			t.Errorf("timeline entry %d = version %d from %v, want version %d from %v", i, e.Version, e.ValidFrom, versions[i], starts[i])
+		}
+		last := i == len(timeline)-1
+		if last && e.ValidTo != nil {
+			t.Errorf("last timeline entry ends at %v, want it open-ended", *e.ValidTo)
+		}
+		if !last && e.ValidTo == nil {
+			t.Errorf("timeline entry %d is open-ended, want it to end at %v", i, starts[i+1])
+		} else if !last && !e.ValidTo.Equal(starts[i+1]) {
+			t.Errorf("timeline entry %d ends at %v, want %v", i, *e.ValidTo, starts[i+1])
+		}
+	}
+}
+
+// mustUpdate updates a record or fails the test
+func mustUpdate(t *testing.T, s service.RecordService, id int, updates map[string]*string) {
+	t.Helper()
+	if _, err := s.UpdateRecord(context.Background(), id, updates); err != nil {
+		t.Fatalf("UpdateRecord(%d): %v", id, err)
+	}
+}
+
+// wantVersions fails the test unless ListVersions returns exactly versions
+func wantVersions(t *testing.T, s service.VersionedRecordService, id int, versions ...int) {
+	t.Helper()
+	infos, err := s.ListVersions(context.Background(), id)
+	if err != nil {
+		t.Fatalf("ListVersions(%d): %v", id, err)
+	}
+	var got []int
+	for _, info := range infos {
+		got = append(got, info.Version)
+	}
+	if !reflect.DeepEqual(got, versions) {
+		t.Errorf("record %d versions = %v, want %v", id, got, versions)
+	}
+}
+
+// wantVersion fails the test unless a version of a record holds data
+func wantVersion(t *testing.T, s service.VersionedRecordService, id, version int, data map[string]string) {
+	t.Helper()
+	got, err := s.GetRecordVersion(context.Background(), id, version)
+	if err != nil {
+		t.Fatalf("GetRecordVersion(%d, %d): %v", id, version, err)
+	}
+	wantRecord(t, got, id, data)
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// SourceRecordService implements RecordService on top of a
+// VersionedRecordService, so that every write made through it is kept in the
+// record's version history. The versions it writes are marked with its source.
+type SourceRecordService struct {
+	versioned VersionedRecordService
+	source    string
+}
+
+// NewSourceRecordService creates a new SourceRecordService whose writes go
+// through versioned and are marked with source
+func NewSourceRecordService(versioned VersionedRecordService, source string) *SourceRecordService {
+	return &SourceRecordService{versioned: versioned, source: source}
+}
+
+// GetRecord retrieves the latest version of a record. A deleted record does
+// not exist as far as RecordService is concerned, so creating it brings it back.
+func (s *SourceRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
+	record, err := s.versioned.GetRecord(ctx, id)
+	if errors.Is(err, ErrRecordDeleted) {
+		return entity.Record{}, ErrRecordDoesNotExist
+	}
+	return record, err
+}
+
+// CreateRecord inserts a new record and creates its first version
+func (s *SourceRecordService) CreateRecord(ctx context.Context, record entity.Record) error {
+	return s.versioned.CreateRecord(s.withSource(ctx), record)
+}
+
+// UpdateRecord updates a record and creates a new version
+func (s *SourceRecordService) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	return s.versioned.UpdateRecord(s.withSource(ctx), id, updates)
+}
+
+// withSource returns a copy of ctx whose change metadata names the service's
+// source, keeping any author and reason already attached
+func (s *SourceRecordService) withSource(ctx context.Context) context.Context {
+	metadata := changeMetadata(ctx)
+	metadata.Source = s.source
+	return WithChangeMetadata(ctx, metadata)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,7 +12,9 @@ import (
 	"github.com/rainbowmga/timetravel/entity"
 )
 
-// SQLiteRecordService implements RecordService using SQLite database
+// SQLiteRecordService implements RecordService using SQLite database. It keeps
+// no version history; use a SourceRecordService over a
+// SQLiteVersionedRecordService to serve RecordService with history.
 type SQLiteRecordService struct {
 	db *database.DB
 }
@@ -21,14 +24,15 @@ func NewSQLiteRecordService(db *database.DB) *SQLiteRecordService {
 	return &SQLiteRecordService{db: db}
 }
 
-// GetRecord retrieves a record by ID
+// GetRecord retrieves a record by ID. Deleted records do not exist as far as
+// RecordService is concerned.
 func (s *SQLiteRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
 	if id <= 0 {
 		return entity.Record{}, ErrRecordIDInvalid
 	}
 
 	var dataJSON string
-	err := s.db.QueryRowContext(ctx, "SELECT data FROM records WHERE id = ?", id).Scan(&dataJSON)
+	err := s.db.QueryRowContext(ctx, "SELECT data FROM records WHERE id = ? AND deleted_at IS NULL", id).Scan(&dataJSON)
 	if err == sql.ErrNoRows {
 		return entity.Record{}, ErrRecordDoesNotExist
 	}
@@ -54,9 +58,16 @@ func (s *SQLiteRecordService) CreateRecord(ctx context.Context, record entity.Re
 		return ErrRecordIDInvalid
 	}
 
-	// Check if record already exists
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Check if record already exists; a deleted one is created again
 	var exists bool
-	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM records WHERE id = ?)", id).Scan(&exists)
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM records WHERE id = ? AND deleted_at IS NULL)", id).Scan(&exists)
 	if err != nil {
 		return fmt.Errorf("failed to check record existence: %w", err)
 	}
@@ -64,19 +75,19 @@ func (s *SQLiteRecordService) CreateRecord(ctx context.Context, record entity.Re
 		return ErrRecordAlreadyExists
 	}
 
-	// Serialize data to JSON
-	dataJSON, err := json.Marshal(record.Data)
+	// Insert record
+	err = appendEvent(ctx, tx, recordEvent{
+		RecordID:  id,
+		Type:      eventCreated,
+		Data:      record.Data,
+		CreatedAt: time.Now(),
+	})
 	if err != nil {
-		return fmt.Errorf("failed to marshal record data: %w", err)
+		return err
 	}
 
-	// Insert record
-	_, err = s.db.ExecContext(ctx,
-		"INSERT INTO records (id, data, created_at, updated_at) VALUES (?, ?, ?, ?)",
-		id, string(dataJSON), time.Now(), time.Now(),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to insert record: %w", err)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
@@ -88,36 +99,41 @@ func (s *SQLiteRecordService) UpdateRecord(ctx context.Context, id int, updates
 		return entity.Record{}, ErrRecordIDInvalid
 	}
 
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Record{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	// Get current record
-	record, err := s.GetRecord(ctx, id)
+	current, err := readCurrent(ctx, tx, id)
+	if errors.Is(err, ErrRecordDeleted) {
+		return entity.Record{}, ErrRecordDoesNotExist
+	}
 	if err != nil {
 		return entity.Record{}, err
 	}
 
 	// Apply updates
-	for key, value := range updates {
-		if value == nil {
-			// Delete key
-			delete(record.Data, key)
-		} else {
-			// Update or add key
-			record.Data[key] = *value
-		}
-	}
-
-	// Serialize updated data to JSON
-	dataJSON, err := json.Marshal(record.Data)
-	if err != nil {
-		return entity.Record{}, fmt.Errorf("failed to marshal record data: %w", err)
+	record := entity.Record{
+		ID:   id,
+		Data: applyUpdates(current, updates),
 	}
 
 	// Update record in database
-	_, err = s.db.ExecContext(ctx,
-		"UPDATE records SET data = ?, updated_at = ? WHERE id = ?",
-		string(dataJSON), time.Now(), id,
-	)
+	err = appendEvent(ctx, tx, recordEvent{
+		RecordID:  id,
+		Type:      eventUpdated,
+		Data:      record.Data,
+		CreatedAt: time.Now(),
+	})
 	if err != nil {
-		return entity.Record{}, fmt.Errorf("failed to update record: %w", err)
+		return entity.Record{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return entity.Record{}, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return record, nil
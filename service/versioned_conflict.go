@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+var ErrVersionConflict = errors.New("record was changed since the expected version")
+
+// AnyVersion matches every version of a record that exists when passed to
+// UpdateRecordIfVersion, as If-Match: * does
+const AnyVersion = -1
+
+// VersionConflictError is returned by UpdateRecordIfVersion when a record's
+// latest version is none of those the update expected. It matches
+// ErrVersionConflict with errors.Is.
+type VersionConflictError struct {
+	Expected []int
+	Current  int
+}
+
+func (e *VersionConflictError) Error() string {
+	expected := make([]string, len(e.Expected))
+	for i, version := range e.Expected {
+		expected[i] = strconv.Itoa(version)
+	}
+	return fmt.Sprintf("%v: expected version %s, latest is %d", ErrVersionConflict, strings.Join(expected, " or "), e.Current)
+}
+
+func (e *VersionConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+// GetRecordWithVersion retrieves the current state of a record and the number
+// of its latest version in a single read
+func (s *SQLiteVersionedRecordService) GetRecordWithVersion(ctx context.Context, id int) (entity.Record, int, error) {
+	if id <= 0 {
+		return entity.Record{}, 0, ErrRecordIDInvalid
+	}
+
+	var version int
+	var dataJSON string
+	var deleted bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT data, deleted_at IS NOT NULL, (SELECT COALESCE(MAX(version), 0) FROM record_versions WHERE record_id = records.id)
+		FROM records WHERE id = ?`,
+		id,
+	).Scan(&dataJSON, &deleted, &version)
+	if err == sql.ErrNoRows {
+		return entity.Record{}, 0, ErrRecordDoesNotExist
+	}
+	if err != nil {
+		return entity.Record{}, 0, fmt.Errorf("failed to query record: %w", err)
+	}
+	if deleted {
+		return entity.Record{}, 0, ErrRecordDeleted
+	}
+
+	var data map[string]string
+	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
+		return entity.Record{}, 0, fmt.Errorf("failed to unmarshal record data: %w", err)
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: data,
+	}, version, nil
+}
+
+// UpdateRecordIfVersion updates a record and creates a new version if its
+// latest version is still one of versions. The check and the write happen in
+// the same transaction, so no other write can come between them.
+func (s *SQLiteVersionedRecordService) UpdateRecordIfVersion(ctx context.Context, id int, versions []int, updates map[string]*string) (entity.Record, int, error) {
+	if id <= 0 {
+		return entity.Record{}, 0, ErrRecordIDInvalid
+	}
+	if err := validExpectedVersions(versions); err != nil {
+		return entity.Record{}, 0, err
+	}
+
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Record{}, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return entity.Record{}, 0, ErrEffectiveTimeInFuture
+	}
+
+	current, err := latestVersion(ctx, tx, id)
+	if err != nil {
+		return entity.Record{}, 0, err
+	}
+	// A record written before versions were kept has none yet, so it only
+	// matches AnyVersion
+	if current == 0 {
+		if _, err := readCurrent(ctx, tx, id); err != nil {
+			return entity.Record{}, 0, err
+		}
+	}
+	if !versionMatches(versions, current) {
+		return entity.Record{}, 0, &VersionConflictError{Expected: versions, Current: current}
+	}
+
+	record, err := updateInTx(ctx, tx, id, updates, now, effective)
+	if err != nil {
+		return entity.Record{}, 0, err
+	}
+
+	latest, err := latestVersion(ctx, tx, id)
+	if err != nil {
+		return entity.Record{}, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return entity.Record{}, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return record, latest, nil
+}
+
+// latestVersion returns the number of the latest version of a record, or 0 if
+// it has none
+func latestVersion(ctx context.Context, q queryer, id int) (int, error) {
+	var version int
+	err := q.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM record_versions WHERE record_id = ?", id).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get latest version: %w", err)
+	}
+	return version, nil
+}
+
+// validExpectedVersions checks the versions an update expects, each of which
+// must be a version number or AnyVersion. No versions match no version.
+func validExpectedVersions(versions []int) error {
+	for _, version := range versions {
+		if version <= 0 && version != AnyVersion {
+			return ErrInvalidVersion
+		}
+	}
+	return nil
+}
+
+// versionMatches reports whether current, the latest version of a record that
+// exists, is one of versions. A record without versions has current 0.
+func versionMatches(versions []int, current int) bool {
+	for _, version := range versions {
+		if version == current || version == AnyVersion {
+			return true
+		}
+	}
+	return false
+}
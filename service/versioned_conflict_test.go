@@ -0,0 +1,37 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// TestUpdateRecordIfVersionWithoutVersions updates a record written before
+// versions were kept, which has a row but no versions yet
+func TestUpdateRecordIfVersionWithoutVersions(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := service.NewSQLiteVersionedRecordService(db)
+
+	if _, err := db.Exec(`INSERT INTO records (id, data) VALUES (1, '{"a":"1"}')`); err != nil {
+		t.Fatalf("insert legacy record: %v", err)
+	}
+	value := "2"
+	updates := map[string]*string{"a": &value}
+
+	_, _, err := s.UpdateRecordIfVersion(ctx, 1, []int{1}, updates)
+	var conflict *service.VersionConflictError
+	if !errors.As(err, &conflict) || conflict.Current != 0 {
+		t.Fatalf("UpdateRecordIfVersion = %v, want a conflict with current version 0", err)
+	}
+
+	record, version, err := s.UpdateRecordIfVersion(ctx, 1, []int{service.AnyVersion}, updates)
+	if err != nil {
+		t.Fatalf("UpdateRecordIfVersion(AnyVersion): %v", err)
+	}
+	if version != 1 || record.Data["a"] != "2" {
+		t.Errorf("UpdateRecordIfVersion(AnyVersion) = %v, version %d; want a=2 at version 1", record.Data, version)
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// CorrectRecord applies updates retroactively from effectiveFrom, appending a
+// version for every interval of the record's timeline the correction changes
+func (s *SQLiteVersionedRecordService) CorrectRecord(ctx context.Context, id int, effectiveFrom time.Time, updates map[string]*string) (entity.CorrectionReport, error) {
+	if id <= 0 {
+		return entity.CorrectionReport{}, ErrRecordIDInvalid
+	}
+
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.CorrectionReport{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if effectiveFrom.After(now) {
+		return entity.CorrectionReport{}, ErrEffectiveTimeInFuture
+	}
+
+	current, err := readCurrent(ctx, tx, id)
+	if err != nil {
+		return entity.CorrectionReport{}, err
+	}
+
+	versions, err := loadVersions(ctx, tx, id)
+	if err != nil {
+		return entity.CorrectionReport{}, err
+	}
+
+	report := entity.CorrectionReport{
+		ID:            id,
+		EffectiveFrom: effectiveFrom,
+		Intervals:     []entity.CorrectedInterval{},
+	}
+
+	for _, step := range planCorrection(timeline(versions), effectiveFrom, updates) {
+		version, err := insertVersion(ctx, tx, entity.RecordVersion{
+			RecordID:       id,
+			Data:           step.new,
+			CreatedAt:      now,
+			EffectiveFrom:  step.from,
+			ChangeMetadata: changeMetadata(ctx),
+		})
+		if err != nil {
+			return entity.CorrectionReport{}, err
+		}
+
+		// The last interval is open-ended, so correcting it changes the current state
+		if step.to == nil {
+			current = step.new
+		}
+
+		report.Intervals = append(report.Intervals, entity.CorrectedInterval{
+			Version:   version,
+			Replaces:  step.replaces,
+			ValidFrom: step.from,
+			ValidTo:   step.to,
+			Changes:   diffData(step.old, step.new),
+		})
+	}
+
+	if len(report.Intervals) > 0 {
+		err := appendEvent(ctx, tx, recordEvent{
+			RecordID:       id,
+			Type:           eventCorrected,
+			Data:           current,
+			CreatedAt:      now,
+			ChangeMetadata: changeMetadata(ctx),
+		})
+		if err != nil {
+			return entity.CorrectionReport{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return entity.CorrectionReport{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return report, nil
+}
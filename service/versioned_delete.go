@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// DeleteRecord appends a tombstone version to a record and marks it deleted.
+// The record keeps its last live state, so it can be undeleted.
+func (s *SQLiteVersionedRecordService) DeleteRecord(ctx context.Context, id int) error {
+	if id <= 0 {
+		return ErrRecordIDInvalid
+	}
+
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return ErrEffectiveTimeInFuture
+	}
+
+	data, err := readCurrent(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := checkEffective(ctx, tx, id, effective); err != nil {
+		return err
+	}
+
+	err = appendEvent(ctx, tx, recordEvent{
+		RecordID:       id,
+		Type:           eventDeleted,
+		Data:           data,
+		CreatedAt:      now,
+		ChangeMetadata: changeMetadata(ctx),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = insertVersion(ctx, tx, entity.RecordVersion{
+		RecordID:       id,
+		Data:           map[string]string{},
+		CreatedAt:      now,
+		EffectiveFrom:  effective,
+		Deleted:        true,
+		ChangeMetadata: changeMetadata(ctx),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UndeleteRecord appends a new version holding the state a deleted record had
+// when it was deleted and makes it current again
+func (s *SQLiteVersionedRecordService) UndeleteRecord(ctx context.Context, id int) (entity.Record, error) {
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Record{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return entity.Record{}, ErrEffectiveTimeInFuture
+	}
+
+	data, deleted, err := readState(ctx, tx, id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+	if !deleted {
+		return entity.Record{}, ErrRecordNotDeleted
+	}
+
+	if err := checkEffective(ctx, tx, id, effective); err != nil {
+		return entity.Record{}, err
+	}
+
+	err = appendEvent(ctx, tx, recordEvent{
+		RecordID:       id,
+		Type:           eventUndeleted,
+		Data:           data,
+		CreatedAt:      now,
+		ChangeMetadata: changeMetadata(ctx),
+	})
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	_, err = insertVersion(ctx, tx, entity.RecordVersion{
+		RecordID:       id,
+		Data:           data,
+		CreatedAt:      now,
+		EffectiveFrom:  effective,
+		ChangeMetadata: changeMetadata(ctx),
+	})
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return entity.Record{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: data,
+	}, nil
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+var (
+	ErrIndexKeyInvalid = errors.New("index key must not be empty")
+	ErrKeyNotIndexed   = errors.New("key is not indexed")
+	ErrIndexAsOf       = errors.New("indexes hold only the current state of records")
+)
+
+// IndexService looks records up by the value they hold at an indexed key of
+// their data, without scanning every record. It is implemented by backends
+// that keep an index of their records.
+type IndexService interface {
+	// AddIndex indexes a key, indexing every record that holds it, and
+	// returns the index. Adding a key that is already indexed does nothing.
+	AddIndex(ctx context.Context, key string) (entity.Index, error)
+
+	// DropIndex stops indexing a key and removes its index
+	DropIndex(ctx context.Context, key string) error
+
+	// ListIndexes returns every indexed key, in key order
+	ListIndexes(ctx context.Context) ([]entity.Index, error)
+
+	// ListRecordsByIndex returns a page of the records that are not deleted
+	// holding value at an indexed key, in ID order, narrowed further by the
+	// cursor, limit, UpdatedSince and Filters of q. Indexes hold the current
+	// state of records only, so q.AsOf must be zero.
+	ListRecordsByIndex(ctx context.Context, key, value string, q RecordQuery) (entity.RecordPage, error)
+}
+
+// indexEntries inserts the index entries of every record that is not deleted
+// for the indexed keys it holds
+const indexEntries = `INSERT INTO record_index_entries (key, value, record_id)
+	SELECT j.key, j.value, records.id FROM records, json_each(records.data) j
+	JOIN record_indexes ON record_indexes.key = j.key
+	WHERE records.deleted_at IS NULL`
+
+// AddIndex indexes a key and backfills its index from the current state of
+// every record in a single transaction
+func (s *SQLiteVersionedRecordService) AddIndex(ctx context.Context, key string) (entity.Index, error) {
+	if key == "" {
+		return entity.Index{}, ErrIndexKeyInvalid
+	}
+
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Index{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO record_indexes (key, created_at) VALUES (?, ?) ON CONFLICT (key) DO NOTHING",
+		key, now,
+	)
+	if err != nil {
+		return entity.Index{}, fmt.Errorf("failed to add index: %w", err)
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return entity.Index{}, fmt.Errorf("failed to add index: %w", err)
+	}
+
+	if added > 0 {
+		if _, err := tx.ExecContext(ctx, indexEntries+" AND j.key = ?", key); err != nil {
+			return entity.Index{}, fmt.Errorf("failed to backfill index: %w", err)
+		}
+	}
+
+	index, err := loadIndex(ctx, tx, key)
+	if err != nil {
+		return entity.Index{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return entity.Index{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return index, nil
+}
+
+// DropIndex stops indexing a key. Its entries are removed with it.
+func (s *SQLiteVersionedRecordService) DropIndex(ctx context.Context, key string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM record_indexes WHERE key = ?", key)
+	if err != nil {
+		return fmt.Errorf("failed to drop index: %w", err)
+	}
+	dropped, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to drop index: %w", err)
+	}
+	if dropped == 0 {
+		return ErrKeyNotIndexed
+	}
+	return nil
+}
+
+// ListIndexes returns every indexed key, in key order
+func (s *SQLiteVersionedRecordService) ListIndexes(ctx context.Context) ([]entity.Index, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT key, created_at, (SELECT COUNT(*) FROM record_index_entries e WHERE e.key = record_indexes.key)
+		FROM record_indexes ORDER BY key ASC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query indexes: %w", err)
+	}
+	defer rows.Close()
+
+	indexes := []entity.Index{}
+	for rows.Next() {
+		var index entity.Index
+		if err := rows.Scan(&index.Key, &index.CreatedAt, &index.Records); err != nil {
+			return nil, fmt.Errorf("failed to scan index: %w", err)
+		}
+		indexes = append(indexes, index)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating indexes: %w", err)
+	}
+
+	return indexes, nil
+}
+
+// ListRecordsByIndex returns a page of the records that are not deleted
+// holding value at an indexed key, reading their IDs from the index
+func (s *SQLiteVersionedRecordService) ListRecordsByIndex(ctx context.Context, key, value string, q RecordQuery) (entity.RecordPage, error) {
+	if !q.AsOf.IsZero() {
+		return entity.RecordPage{}, ErrIndexAsOf
+	}
+
+	after, limit, err := pageBounds(q)
+	if err != nil {
+		return entity.RecordPage{}, err
+	}
+
+	if _, err := loadIndex(ctx, s.db, key); err != nil {
+		return entity.RecordPage{}, err
+	}
+
+	return s.listCurrent(ctx, q, after, limit,
+		"id IN (SELECT record_id FROM record_index_entries WHERE key = ? AND value = ? AND record_id > ?)",
+		[]interface{}{key, value, after},
+	)
+}
+
+// loadIndex returns an indexed key, or ErrKeyNotIndexed if it is not indexed
+func loadIndex(ctx context.Context, q queryer, key string) (entity.Index, error) {
+	index := entity.Index{Key: key}
+	err := q.QueryRowContext(ctx,
+		`SELECT created_at, (SELECT COUNT(*) FROM record_index_entries WHERE key = ?)
+		FROM record_indexes WHERE key = ?`,
+		key, key,
+	).Scan(&index.CreatedAt, &index.Records)
+	if err == sql.ErrNoRows {
+		return entity.Index{}, ErrKeyNotIndexed
+	}
+	if err != nil {
+		return entity.Index{}, fmt.Errorf("failed to query index: %w", err)
+	}
+	return index, nil
+}
+
+// reindex replaces the index entries of a record with those of dataJSON, its
+// new current state. A deleted record has none.
+func reindex(ctx context.Context, tx *sql.Tx, id int, dataJSON string, deleted bool) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM record_index_entries WHERE record_id = ?", id); err != nil {
+		return fmt.Errorf("failed to update index: %w", err)
+	}
+	if deleted {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO record_index_entries (key, value, record_id)
+		SELECT j.key, j.value, ? FROM json_each(?) j
+		JOIN record_indexes ON record_indexes.key = j.key`,
+		id, dataJSON,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// wantIndexed checks that the records listed by the index of key for value
+// are exactly ids
+func wantIndexed(t *testing.T, s service.IndexService, key, value string, ids ...int) {
+	t.Helper()
+	page, err := s.ListRecordsByIndex(context.Background(), key, value, service.RecordQuery{})
+	if err != nil {
+		t.Fatalf("ListRecordsByIndex(%s, %s): %v", key, value, err)
+	}
+	var got []int
+	for _, record := range page.Records {
+		got = append(got, record.ID)
+	}
+	if !reflect.DeepEqual(got, ids) {
+		t.Errorf("ListRecordsByIndex(%s, %s) = %v, want %v", key, value, got, ids)
+	}
+}
+
+// TestAddIndex indexes a key that existing records already hold
+func TestAddIndex(t *testing.T) {
+	ctx := context.Background()
+	s := service.NewSQLiteVersionedRecordService(newTestDB(t))
+
+	for id, data := range map[int]map[string]string{
+		1: {"state": "ca"},
+		2: {"state": "ny"},
+		3: {"other": "ca"},
+		4: {"state": "ca"},
+	} {
+		if err := s.CreateRecord(ctx, entity.Record{ID: id, Data: data}); err != nil {
+			t.Fatalf("CreateRecord(%d): %v", id, err)
+		}
+	}
+	if err := s.DeleteRecord(ctx, 4); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+
+	index, err := s.AddIndex(ctx, "state")
+	if err != nil || index.Key != "state" || index.Records != 2 {
+		t.Fatalf("AddIndex = %+v, %v; want 2 records indexed", index, err)
+	}
+	wantIndexed(t, s, "state", "ca", 1)
+	wantIndexed(t, s, "state", "ny", 2)
+
+	// Adding it again keeps the index as it was
+	again, err := s.AddIndex(ctx, "state")
+	if err != nil || !again.CreatedAt.Equal(index.CreatedAt) || again.Records != 2 {
+		t.Errorf("AddIndex again = %+v, %v; want %+v", again, err, index)
+	}
+
+	if _, err := s.AddIndex(ctx, ""); !errors.Is(err, service.ErrIndexKeyInvalid) {
+		t.Errorf("AddIndex(\"\") = %v, want ErrIndexKeyInvalid", err)
+	}
+	if _, err := s.ListRecordsByIndex(ctx, "other", "ca", service.RecordQuery{}); !errors.Is(err, service.ErrKeyNotIndexed) {
+		t.Errorf("ListRecordsByIndex on a key that is not indexed = %v, want ErrKeyNotIndexed", err)
+	}
+	_, err = s.ListRecordsByIndex(ctx, "state", "ca", service.RecordQuery{AsOf: time.Now()})
+	if !errors.Is(err, service.ErrIndexAsOf) {
+		t.Errorf("ListRecordsByIndex as of a time = %v, want ErrIndexAsOf", err)
+	}
+}
+
+// TestIndexFollowsWrites checks that every kind of write updates the index
+// along with the record, and that a write that fails leaves it alone
+func TestIndexFollowsWrites(t *testing.T) {
+	ctx := context.Background()
+	s := service.NewSQLiteVersionedRecordService(newTestDB(t))
+	if _, err := s.AddIndex(ctx, "state"); err != nil {
+		t.Fatalf("AddIndex: %v", err)
+	}
+
+	ca, ny := "ca", "ny"
+	if _, err := s.UpsertRecord(ctx, 1, map[string]*string{"state": &ca}); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	wantIndexed(t, s, "state", "ca", 1)
+
+	if _, err := s.UpdateRecord(ctx, 1, map[string]*string{"state": &ny}); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	wantIndexed(t, s, "state", "ca")
+	wantIndexed(t, s, "state", "ny", 1)
+
+	// A conditional update that fails changes neither
+	_, _, err := s.UpdateRecordIfVersion(ctx, 1, []int{1}, map[string]*string{"state": &ca})
+	if !errors.Is(err, service.ErrVersionConflict) {
+		t.Fatalf("UpdateRecordIfVersion(stale) = %v, want ErrVersionConflict", err)
+	}
+	wantIndexed(t, s, "state", "ca")
+	wantIndexed(t, s, "state", "ny", 1)
+
+	if err := s.DeleteRecord(ctx, 1); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	wantIndexed(t, s, "state", "ny")
+
+	if _, err := s.UndeleteRecord(ctx, 1); err != nil {
+		t.Fatalf("UndeleteRecord: %v", err)
+	}
+	wantIndexed(t, s, "state", "ny", 1)
+
+	if _, err := s.RestoreVersion(ctx, 1, 1); err != nil {
+		t.Fatalf("RestoreVersion: %v", err)
+	}
+	wantIndexed(t, s, "state", "ca", 1)
+	wantIndexed(t, s, "state", "ny")
+
+	if _, err := s.UpdateRecord(ctx, 1, map[string]*string{"state": nil}); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	wantIndexed(t, s, "state", "ca")
+
+	indexes, err := s.ListIndexes(ctx)
+	if err != nil || len(indexes) != 1 || indexes[0].Records != 0 {
+		t.Errorf("ListIndexes = %+v, %v; want the state index with no records", indexes, err)
+	}
+}
+
+// TestDropIndex checks that dropping an index removes its entries
+func TestDropIndex(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := service.NewSQLiteVersionedRecordService(db)
+
+	if err := s.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"state": "ca", "city": "la"}}); err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	for _, key := range []string{"state", "city"} {
+		if _, err := s.AddIndex(ctx, key); err != nil {
+			t.Fatalf("AddIndex(%s): %v", key, err)
+		}
+	}
+
+	if err := s.DropIndex(ctx, "state"); err != nil {
+		t.Fatalf("DropIndex: %v", err)
+	}
+	if _, err := s.ListRecordsByIndex(ctx, "state", "ca", service.RecordQuery{}); !errors.Is(err, service.ErrKeyNotIndexed) {
+		t.Errorf("ListRecordsByIndex after DropIndex = %v, want ErrKeyNotIndexed", err)
+	}
+	if err := s.DropIndex(ctx, "state"); !errors.Is(err, service.ErrKeyNotIndexed) {
+		t.Errorf("DropIndex again = %v, want ErrKeyNotIndexed", err)
+	}
+
+	var entries int
+	if err := db.QueryRow("SELECT COUNT(*) FROM record_index_entries WHERE key = 'state'").Scan(&entries); err != nil {
+		t.Fatalf("count index entries: %v", err)
+	}
+	if entries != 0 {
+		t.Errorf("%d entries left for the dropped index", entries)
+	}
+	wantIndexed(t, s, "city", "la", 1)
+
+	indexes, err := s.ListIndexes(ctx)
+	if err != nil || len(indexes) != 1 || indexes[0].Key != "city" {
+		t.Errorf("ListIndexes = %+v, %v; want only city", indexes, err)
+	}
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// Page sizes of ListRecords
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+var (
+	ErrCursorInvalid   = errors.New("invalid cursor")
+	ErrPageSizeInvalid = fmt.Errorf("page size must be between 1 and %d", MaxPageSize)
+	ErrFilterInvalid   = errors.New("invalid filter")
+)
+
+// Operators of a Filter
+const (
+	// FilterEqual matches records holding the value at the key
+	FilterEqual = "eq"
+	// FilterNotEqual matches records holding another value at the key, or
+	// none at all
+	FilterNotEqual = "ne"
+	// FilterPrefix matches records whose value at the key starts with the
+	// value, compared case-sensitively
+	FilterPrefix = "prefix"
+	// FilterExists matches records holding any value at the key
+	FilterExists = "exists"
+	// FilterMissing matches records holding no value at the key
+	FilterMissing = "missing"
+)
+
+// Filter is a condition on the value a record holds at a key of its data
+type Filter struct {
+	Key string
+	Op  string
+	// Value is ignored by FilterExists and FilterMissing
+	Value string
+}
+
+// matches reports whether data satisfies the filter
+func (f Filter) matches(data map[string]string) bool {
+	value, ok := data[f.Key]
+	switch f.Op {
+	case FilterEqual:
+		return ok && value == f.Value
+	case FilterNotEqual:
+		return !ok || value != f.Value
+	case FilterPrefix:
+		return ok && strings.HasPrefix(value, f.Value)
+	case FilterExists:
+		return ok
+	case FilterMissing:
+		return !ok
+	}
+	return false
+}
+
+// condition returns the SQL condition on the data column of records that
+// holds where the filter matches, and its arguments. The data is searched
+// with json_each, so keys need no escaping.
+func (f Filter) condition() (string, []interface{}) {
+	const has = "EXISTS (SELECT 1 FROM json_each(records.data) WHERE key = ?"
+	switch f.Op {
+	case FilterEqual:
+		return has + " AND value = ?)", []interface{}{f.Key, f.Value}
+	case FilterNotEqual:
+		return "NOT " + has + " AND value = ?)", []interface{}{f.Key, f.Value}
+	case FilterPrefix:
+		return has + " AND substr(value, 1, length(?)) = ?)", []interface{}{f.Key, f.Value, f.Value}
+	case FilterExists:
+		return has + ")", []interface{}{f.Key}
+	default:
+		return "NOT " + has + ")", []interface{}{f.Key}
+	}
+}
+
+// checkFilters returns ErrFilterInvalid if a filter has an unknown operator
+func checkFilters(filters []Filter) error {
+	for _, f := range filters {
+		switch f.Op {
+		case FilterEqual, FilterNotEqual, FilterPrefix, FilterExists, FilterMissing:
+		default:
+			return fmt.Errorf("%w: unknown operator %q", ErrFilterInvalid, f.Op)
+		}
+	}
+	return nil
+}
+
+// matchesAll reports whether data satisfies every filter
+func matchesAll(filters []Filter, data map[string]string) bool {
+	for _, f := range filters {
+		if !f.matches(data) {
+			return false
+		}
+	}
+	return true
+}
+
+// RecordQuery selects a page of records for ListRecords
+type RecordQuery struct {
+	// Cursor continues a listing from the NextCursor of its previous page;
+	// empty to start from the first record
+	Cursor string
+	// Limit is the most records on the page; 0 means DefaultPageSize
+	Limit int
+	// UpdatedSince, unless zero, leaves out records last written before it.
+	// SQLite compares it to the millisecond.
+	UpdatedSince time.Time
+	// Filters leave out records that do not match every one of them
+	Filters []Filter
+	// AsOf, unless zero, lists records as they were recorded at that time
+	// instead of as they are now. Filters and UpdatedSince then apply to
+	// that state.
+	AsOf time.Time
+}
+
+// pageCursor is the position a cursor points at. Cursors are opaque to
+// clients, so what they hold may change without notice.
+type pageCursor struct {
+	After int `json:"after"`
+}
+
+// encodeCursor returns the cursor continuing a listing after record id
+func encodeCursor(id int) string {
+	cursorJSON, _ := json.Marshal(pageCursor{After: id})
+	return base64.RawURLEncoding.EncodeToString(cursorJSON)
+}
+
+// pageBounds returns the ID a page starts after and the number of records it
+// holds, checking the cursor, limit and filters of a query
+func pageBounds(q RecordQuery) (int, int, error) {
+	if err := checkFilters(q.Filters); err != nil {
+		return 0, 0, err
+	}
+
+	limit := q.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit < 0 || limit > MaxPageSize {
+		return 0, 0, ErrPageSizeInvalid
+	}
+
+	if q.Cursor == "" {
+		return 0, limit, nil
+	}
+
+	cursorJSON, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return 0, 0, ErrCursorInvalid
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(cursorJSON, &cursor); err != nil || cursor.After <= 0 {
+		return 0, 0, ErrCursorInvalid
+	}
+
+	return cursor.After, limit, nil
+}
+
+// newPage returns the page holding the first limit of records, which were
+// read with one more record than that to tell whether another page follows
+func newPage(records []entity.Record, limit int) entity.RecordPage {
+	page := entity.RecordPage{Records: records}
+	if len(records) > limit {
+		page.Records = records[:limit]
+		page.NextCursor = encodeCursor(records[limit-1].ID)
+	}
+	return page
+}
+
+// ListRecords returns a page of the records that are not deleted, in ID
+// order. The current state of records is filtered in SQL, with JSON1; a
+// listing as of an earlier time is read from the version history instead.
+func (s *SQLiteVersionedRecordService) ListRecords(ctx context.Context, q RecordQuery) (entity.RecordPage, error) {
+	after, limit, err := pageBounds(q)
+	if err != nil {
+		return entity.RecordPage{}, err
+	}
+
+	if !q.AsOf.IsZero() {
+		return s.listRecordsAsOf(ctx, q, after, limit)
+	}
+
+	return s.listCurrent(ctx, q, after, limit, "", nil)
+}
+
+// listCurrent returns a page of the records that are not deleted and match
+// q, in their current state. A non-empty SQL condition on records, given
+// with its arguments, further narrows the records listed.
+func (s *SQLiteVersionedRecordService) listCurrent(ctx context.Context, q RecordQuery, after, limit int, where string, whereArgs []interface{}) (entity.RecordPage, error) {
+	query := "SELECT id, data FROM records WHERE id > ? AND deleted_at IS NULL"
+	args := []interface{}{after}
+	if where != "" {
+		query += " AND " + where
+		args = append(args, whereArgs...)
+	}
+	if !q.UpdatedSince.IsZero() {
+		query += " AND julianday(updated_at) >= julianday(?)"
+		args = append(args, q.UpdatedSince)
+	}
+	for _, f := range q.Filters {
+		condition, conditionArgs := f.condition()
+		query += " AND " + condition
+		args = append(args, conditionArgs...)
+	}
+	query += " ORDER BY id ASC LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entity.RecordPage{}, fmt.Errorf("failed to query records: %w", err)
+	}
+	defer rows.Close()
+
+	records := []entity.Record{}
+	for rows.Next() {
+		var record entity.Record
+		var dataJSON string
+		if err := rows.Scan(&record.ID, &dataJSON); err != nil {
+			return entity.RecordPage{}, fmt.Errorf("failed to scan record: %w", err)
+		}
+		if err := json.Unmarshal([]byte(dataJSON), &record.Data); err != nil {
+			return entity.RecordPage{}, fmt.Errorf("failed to unmarshal record data: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return entity.RecordPage{}, fmt.Errorf("error iterating records: %w", err)
+	}
+
+	return newPage(records, limit), nil
+}
+
+// listRecordsAsOf returns a page of the records as they were at q.AsOf, read
+// from their versions. The version current at the time stands in for the
+// record, so UpdatedSince applies to the time it was recorded.
+func (s *SQLiteVersionedRecordService) listRecordsAsOf(ctx context.Context, q RecordQuery, after, limit int) (entity.RecordPage, error) {
+	records := []entity.Record{}
+	err := s.scanAsOf(ctx, after, q.AsOf, func(v entity.RecordVersion) error {
+		if v.Deleted || v.CreatedAt.Before(q.UpdatedSince) || !matchesAll(q.Filters, v.Data) {
+			return nil
+		}
+		records = append(records, entity.Record{ID: v.RecordID, Data: v.Data})
+		if len(records) > limit {
+			return errStopScan
+		}
+		return nil
+	})
+	if err != nil {
+		return entity.RecordPage{}, err
+	}
+
+	return newPage(records, limit), nil
+}
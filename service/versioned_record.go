@@ -13,8 +13,13 @@ import (
 )
 
 var (
-	ErrVersionDoesNotExist = errors.New("version does not exist")
-	ErrInvalidVersion      = errors.New("invalid version number")
+	ErrVersionDoesNotExist   = errors.New("version does not exist")
+	ErrInvalidVersion        = errors.New("invalid version number")
+	ErrEffectiveTimeInFuture = errors.New("effective time must not be in the future")
+	ErrEffectiveTimeConflict = errors.New("effective time precedes the record's latest effective version")
+	ErrRecordDeleted         = errors.New("record was deleted")
+	ErrRecordNotDeleted      = errors.New("record is not deleted")
+	ErrVersionDeleted        = errors.New("version is a tombstone")
 )
 
 // VersionedRecordService extends RecordService with versioning capabilities
@@ -24,13 +29,116 @@ type VersionedRecordService interface {
 	// GetRecordVersion retrieves a record at a specific version
 	GetRecordVersion(ctx context.Context, id int, version int) (entity.Record, error)
 
-	// ListVersions returns all versions for a record
+	// GetRecordAsOf retrieves a record as it was current at time t, according
+	// to what had been recorded by then. It fails with ErrVersionCompacted if
+	// the versions current then were removed by retention.
+	GetRecordAsOf(ctx context.Context, id int, t time.Time) (entity.Record, error)
+
+	// GetRecordAt retrieves a record as it was in effect at validAt, according
+	// to what had been recorded by knownAt, failing with ErrVersionCompacted
+	// as GetRecordAsOf does
+	GetRecordAt(ctx context.Context, id int, validAt, knownAt time.Time) (entity.Record, error)
+
+	// DiffVersions describes the keys added, removed and changed between
+	// versions a and b of a record
+	DiffVersions(ctx context.Context, id int, a, b int) (entity.RecordDiff, error)
+
+	// RestoreVersion makes the data of an earlier version current again by
+	// appending a new version with an exact copy of it, effective from the
+	// time carried by ctx as for any other write
+	RestoreVersion(ctx context.Context, id int, version int) (entity.Record, error)
+
+	// DeleteRecord appends a tombstone version to a record. Until the record
+	// is undeleted or written again, reads of its current state fail with
+	// ErrRecordDeleted, while its history stays readable.
+	DeleteRecord(ctx context.Context, id int) error
+
+	// UndeleteRecord makes the last live state of a deleted record current
+	// again by appending a new version holding it
+	UndeleteRecord(ctx context.Context, id int) (entity.Record, error)
+
+	// GetFieldHistory returns the versions in which key was added, changed or
+	// removed, in version order
+	GetFieldHistory(ctx context.Context, id int, key string) ([]entity.FieldChange, error)
+
+	// ListRecords returns a page of the records that are not deleted and
+	// match the query, in ID order. A query with a malformed cursor fails with
+	// ErrCursorInvalid, one with a limit above MaxPageSize with
+	// ErrPageSizeInvalid, and one with an unknown filter operator with
+	// ErrFilterInvalid. A listing as of a time for which retention removed
+	// the versions of a record on the page fails with ErrVersionCompacted.
+	ListRecords(ctx context.Context, q RecordQuery) (entity.RecordPage, error)
+
+	// Snapshot calls fn with every record as it was at time t, in ID order.
+	// Records created after t are left out. If fn returns an error the
+	// snapshot stops and returns it, and it stops with ErrVersionCompacted
+	// at a record whose versions current at t were removed by retention.
+	Snapshot(ctx context.Context, t time.Time, fn func(entity.Record) error) error
+
+	// TagVersion names a version of a record. Tags are unique per record; if
+	// the tag already points at a different version it is not moved and
+	// ErrTagAlreadyExists is returned.
+	TagVersion(ctx context.Context, id int, version int, tag string) error
+
+	// MoveTag points an existing tag of a record at a different version
+	MoveTag(ctx context.Context, id int, version int, tag string) error
+
+	// GetRecordByTag retrieves a record at the version a tag points at
+	GetRecordByTag(ctx context.Context, id int, tag string) (entity.Record, error)
+
+	// ListVersions returns all versions for a record, newest first. Versions
+	// removed by retention are listed as a single marker with Compacted set.
 	ListVersions(ctx context.Context, id int) ([]entity.VersionInfo, error)
 
+	// GetVersionInfo returns the metadata of a single version of a record
+	GetVersionInfo(ctx context.Context, id int, version int) (entity.VersionInfo, error)
+
+	// GetTimeline returns the versions that make up a record's valid-time
+	// history as recorded by knownAt, each with the interval over which it was
+	// in effect, ordered by valid time. Versions superseded by a later
+	// correction of the same interval are left out.
+	GetTimeline(ctx context.Context, id int, knownAt time.Time) ([]entity.TimelineEntry, error)
+
 	// CreateOrUpdateRecord creates or updates a record while preserving history
 	CreateOrUpdateRecord(ctx context.Context, record entity.Record) (entity.Record, error)
+
+	// UpsertRecord applies updates to a record as a single atomic operation.
+	// A record that does not exist is created from the non-null updates;
+	// otherwise null updates delete keys as in UpdateRecord.
+	UpsertRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error)
+
+	// UpsertRecordWithVersion applies updates as in UpsertRecord and returns
+	// the number of the version it appends
+	UpsertRecordWithVersion(ctx context.Context, id int, updates map[string]*string) (entity.Record, int, error)
+
+	// GetRecordWithVersion retrieves the current state of a record together
+	// with the number of its latest version, which changes with every write
+	GetRecordWithVersion(ctx context.Context, id int) (entity.Record, int, error)
+
+	// UpdateRecordIfVersion applies updates as in UpdateRecord only if the
+	// latest version of the record is still one of versions, and returns the
+	// number of the version it appends. AnyVersion matches any version, and
+	// no versions match none. If the latest version does not match, it fails
+	// with a *VersionConflictError and writes nothing.
+	UpdateRecordIfVersion(ctx context.Context, id int, versions []int, updates map[string]*string) (entity.Record, int, error)
+
+	// CorrectRecord applies updates retroactively from effectiveFrom without
+	// rewriting existing versions. A new version is appended for every interval
+	// of the record's valid-time timeline the correction changes, and the
+	// returned report lists those intervals with their old and new values.
+	CorrectRecord(ctx context.Context, id int, effectiveFrom time.Time, updates map[string]*string) (entity.CorrectionReport, error)
 }
 
+// Writes made through a VersionedRecordService are effective from the time
+// they are recorded, unless the context was prepared with WithEffectiveFrom.
+// Reads of a record as it was while deleted, whether current or at a point in
+// time, fail with ErrRecordDeleted. Creating or upserting a deleted record
+// brings it back with the data written, continuing its history.
+// Metadata attached to the context with WithChangeMetadata is recorded with
+// every version written.
+// An effective time may not be in the future, and an update may not be
+// effective before the latest effective version of the record.
+
 // SQLiteVersionedRecordService implements VersionedRecordService using SQLite
 type SQLiteVersionedRecordService struct {
 	db *database.DB
@@ -47,24 +155,49 @@ func (s *SQLiteVersionedRecordService) GetRecord(ctx context.Context, id int) (e
 		return entity.Record{}, ErrRecordIDInvalid
 	}
 
+	data, err := readCurrent(ctx, s.db, id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: data,
+	}, nil
+}
+
+// readCurrent returns the current state of a record, or ErrRecordDeleted if
+// it was deleted
+func readCurrent(ctx context.Context, q queryer, id int) (map[string]string, error) {
+	data, deleted, err := readState(ctx, q, id)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return nil, ErrRecordDeleted
+	}
+	return data, nil
+}
+
+// readState returns the state of a record held by the records projection and
+// whether it was deleted, in which case the state is its last live one
+func readState(ctx context.Context, q queryer, id int) (map[string]string, bool, error) {
 	var dataJSON string
-	err := s.db.QueryRowContext(ctx, "SELECT data FROM records WHERE id = ?", id).Scan(&dataJSON)
+	var deleted bool
+	err := q.QueryRowContext(ctx, "SELECT data, deleted_at IS NOT NULL FROM records WHERE id = ?", id).Scan(&dataJSON, &deleted)
 	if err == sql.ErrNoRows {
-		return entity.Record{}, ErrRecordDoesNotExist
+		return nil, false, ErrRecordDoesNotExist
 	}
 	if err != nil {
-		return entity.Record{}, fmt.Errorf("failed to query record: %w", err)
+		return nil, false, fmt.Errorf("failed to query record: %w", err)
 	}
 
 	var data map[string]string
 	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
-		return entity.Record{}, fmt.Errorf("failed to unmarshal record data: %w", err)
+		return nil, false, fmt.Errorf("failed to unmarshal record data: %w", err)
 	}
 
-	return entity.Record{
-		ID:   id,
-		Data: data,
-	}, nil
+	return data, deleted, nil
 }
 
 // GetRecordVersion retrieves a record at a specific version
@@ -76,29 +209,213 @@ func (s *SQLiteVersionedRecordService) GetRecordVersion(ctx context.Context, id
 		return entity.Record{}, ErrInvalidVersion
 	}
 
-	var dataJSON string
-	err := s.db.QueryRowContext(ctx,
-		"SELECT data FROM record_versions WHERE record_id = ? AND version = ?",
-		id, version,
-	).Scan(&dataJSON)
-	if err == sql.ErrNoRows {
-		return entity.Record{}, ErrVersionDoesNotExist
+	data, err := readVersion(ctx, s.db, id, version)
+	if err != nil {
+		return entity.Record{}, err
 	}
+
+	return entity.Record{
+		ID:   id,
+		Data: data,
+	}, nil
+}
+
+// readVersion returns the data of a record at a specific version, applying
+// the deltas stored since the latest keyframe
+func readVersion(ctx context.Context, q queryer, id int, version int) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT version, data, keyframe FROM record_versions
+		WHERE record_id = ? AND version <= ? AND version >= (
+			SELECT MAX(version) FROM record_versions WHERE record_id = ? AND version <= ? AND keyframe = 1
+		)
+		ORDER BY version ASC`,
+		id, version, id, version,
+	)
 	if err != nil {
-		return entity.Record{}, fmt.Errorf("failed to query record version: %w", err)
+		return nil, fmt.Errorf("failed to query record version: %w", err)
 	}
+	defer rows.Close()
 
-	var data map[string]string
-	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
-		return entity.Record{}, fmt.Errorf("failed to unmarshal record data: %w", err)
+	var decoder versionDecoder
+	var v entity.RecordVersion
+	for rows.Next() {
+		v = entity.RecordVersion{RecordID: id}
+		var stored string
+		var keyframe bool
+		if err := rows.Scan(&v.Version, &stored, &keyframe); err != nil {
+			return nil, fmt.Errorf("failed to scan record version: %w", err)
+		}
+		if err := decoder.decode(&v, stored, keyframe); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query record version: %w", err)
+	}
+
+	if v.Version != version {
+		return nil, ErrVersionDoesNotExist
+	}
+
+	return v.Data, nil
+}
+
+// DiffVersions compares versions a and b of a record
+func (s *SQLiteVersionedRecordService) DiffVersions(ctx context.Context, id int, a, b int) (entity.RecordDiff, error) {
+	from, err := s.GetRecordVersion(ctx, id, a)
+	if err != nil {
+		return entity.RecordDiff{}, err
+	}
+
+	to, err := s.GetRecordVersion(ctx, id, b)
+	if err != nil {
+		return entity.RecordDiff{}, err
+	}
+
+	return diffVersions(id, a, b, from.Data, to.Data), nil
+}
+
+// GetRecordAsOf retrieves the version of a record that was current at time t
+func (s *SQLiteVersionedRecordService) GetRecordAsOf(ctx context.Context, id int, t time.Time) (entity.Record, error) {
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+
+	versions, err := loadVersions(ctx, s.db, id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	version, ok := versionAsOf(versions, t)
+	if !ok {
+		return entity.Record{}, missingAt(ctx, s.db, id, t)
+	}
+	if version.Deleted {
+		return entity.Record{}, ErrRecordDeleted
 	}
 
 	return entity.Record{
 		ID:   id,
-		Data: data,
+		Data: version.Data,
 	}, nil
 }
 
+// GetRecordAt retrieves the version of a record in effect at validAt as known at knownAt
+func (s *SQLiteVersionedRecordService) GetRecordAt(ctx context.Context, id int, validAt, knownAt time.Time) (entity.Record, error) {
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+
+	versions, err := loadVersions(ctx, s.db, id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	version, ok := versionAt(versions, validAt, knownAt)
+	if !ok {
+		return entity.Record{}, missingAt(ctx, s.db, id, knownAt)
+	}
+	if version.Deleted {
+		return entity.Record{}, ErrRecordDeleted
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: version.Data,
+	}, nil
+}
+
+// GetFieldHistory returns every change made to a single key of a record
+func (s *SQLiteVersionedRecordService) GetFieldHistory(ctx context.Context, id int, key string) ([]entity.FieldChange, error) {
+	if id <= 0 {
+		return nil, ErrRecordIDInvalid
+	}
+
+	versions, err := loadVersions(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		var exists bool
+		err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM records WHERE id = ?)", id).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check record existence: %w", err)
+		}
+		if !exists {
+			return nil, ErrRecordDoesNotExist
+		}
+	}
+
+	return fieldHistory(versions, key), nil
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// versionColumns are the record_versions columns read by scanVersion
+const versionColumns = "id, record_id, version, data, keyframe, created_at, effective_from, restored_from, deleted, author, reason, source, hash"
+
+// scanVersion reads a version selected with versionColumns. Rows must be read
+// in record and version order through the same decoder so that deltas can be
+// applied to the version before them.
+func scanVersion(rows *sql.Rows, decoder *versionDecoder) (entity.RecordVersion, error) {
+	v, stored, keyframe, err := scanStoredVersion(rows)
+	if err != nil {
+		return entity.RecordVersion{}, err
+	}
+	if err := decoder.decode(&v, stored, keyframe); err != nil {
+		return entity.RecordVersion{}, err
+	}
+	return v, nil
+}
+
+// scanStoredVersion reads a version selected with versionColumns without
+// decoding its data, returning the data as stored instead
+func scanStoredVersion(rows *sql.Rows) (entity.RecordVersion, string, bool, error) {
+	var v entity.RecordVersion
+	var stored string
+	var keyframe bool
+	var restoredFrom sql.NullInt64
+	if err := rows.Scan(&v.ID, &v.RecordID, &v.Version, &stored, &keyframe, &v.CreatedAt, &v.EffectiveFrom, &restoredFrom, &v.Deleted, &v.Author, &v.Reason, &v.Source, &v.Hash); err != nil {
+		return entity.RecordVersion{}, "", false, fmt.Errorf("failed to scan version: %w", err)
+	}
+	v.RestoredFrom = int(restoredFrom.Int64)
+	return v, stored, keyframe, nil
+}
+
+// loadVersions returns every version of a record, ordered by version ascending
+func loadVersions(ctx context.Context, q queryer, id int) ([]entity.RecordVersion, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT "+versionColumns+" FROM record_versions WHERE record_id = ? ORDER BY version ASC",
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query versions: %w", err)
+	}
+	defer rows.Close()
+
+	var decoder versionDecoder
+	var versions []entity.RecordVersion
+	for rows.Next() {
+		v, err := scanVersion(rows, &decoder)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating versions: %w", err)
+	}
+
+	return versions, nil
+}
+
 // ListVersions returns all versions for a record, ordered by version descending
 func (s *SQLiteVersionedRecordService) ListVersions(ctx context.Context, id int) ([]entity.VersionInfo, error) {
 	if id <= 0 {
@@ -106,7 +423,7 @@ func (s *SQLiteVersionedRecordService) ListVersions(ctx context.Context, id int)
 	}
 
 	rows, err := s.db.QueryContext(ctx,
-		"SELECT version, created_at FROM record_versions WHERE record_id = ? ORDER BY version DESC",
+		"SELECT "+versionInfoColumns+" FROM record_versions WHERE record_id = ? ORDER BY version DESC",
 		id,
 	)
 	if err != nil {
@@ -116,9 +433,9 @@ func (s *SQLiteVersionedRecordService) ListVersions(ctx context.Context, id int)
 
 	var versions []entity.VersionInfo
 	for rows.Next() {
-		var v entity.VersionInfo
-		if err := rows.Scan(&v.Version, &v.CreatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan version: %w", err)
+		v, err := scanVersionInfo(rows)
+		if err != nil {
+			return nil, err
 		}
 		versions = append(versions, v)
 	}
@@ -127,6 +444,14 @@ func (s *SQLiteVersionedRecordService) ListVersions(ctx context.Context, id int)
 		return nil, fmt.Errorf("error iterating versions: %w", err)
 	}
 
+	tags, err := loadTags(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		versions[i].Tags = tags[versions[i].Version]
+	}
+
 	// If no versions found, check if record exists
 	if len(versions) == 0 {
 		var exists bool
@@ -139,9 +464,108 @@ func (s *SQLiteVersionedRecordService) ListVersions(ctx context.Context, id int)
 		}
 	}
 
+	// Versions pruned by retention are listed as a single marker after the
+	// oldest remaining version
+	marker, err := loadCompaction(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if marker != nil {
+		versions = append(versions, *marker)
+	}
+
 	return versions, nil
 }
 
+// GetVersionInfo returns the metadata of a single version of a record
+func (s *SQLiteVersionedRecordService) GetVersionInfo(ctx context.Context, id int, version int) (entity.VersionInfo, error) {
+	if id <= 0 {
+		return entity.VersionInfo{}, ErrRecordIDInvalid
+	}
+	if version <= 0 {
+		return entity.VersionInfo{}, ErrInvalidVersion
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+versionInfoColumns+" FROM record_versions WHERE record_id = ? AND version = ?",
+		id, version,
+	)
+	if err != nil {
+		return entity.VersionInfo{}, fmt.Errorf("failed to query version: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return entity.VersionInfo{}, fmt.Errorf("failed to query version: %w", err)
+		}
+		return entity.VersionInfo{}, ErrVersionDoesNotExist
+	}
+
+	info, err := scanVersionInfo(rows)
+	if err != nil {
+		return entity.VersionInfo{}, err
+	}
+	rows.Close()
+
+	tags, err := loadTags(ctx, s.db, id)
+	if err != nil {
+		return entity.VersionInfo{}, err
+	}
+	info.Tags = tags[version]
+
+	return info, nil
+}
+
+// GetTimeline returns the valid-time intervals of a record's history
+func (s *SQLiteVersionedRecordService) GetTimeline(ctx context.Context, id int, knownAt time.Time) ([]entity.TimelineEntry, error) {
+	if id <= 0 {
+		return nil, ErrRecordIDInvalid
+	}
+
+	versions, err := loadVersions(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		var exists bool
+		err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM records WHERE id = ?)", id).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check record existence: %w", err)
+		}
+		if !exists {
+			return nil, ErrRecordDoesNotExist
+		}
+	}
+
+	tags, err := loadTags(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := timelineEntries(versions, knownAt)
+	for i := range entries {
+		entries[i].Tags = tags[entries[i].Version]
+	}
+
+	return entries, nil
+}
+
+// versionInfoColumns are the record_versions columns read by scanVersionInfo
+const versionInfoColumns = "version, created_at, effective_from, restored_from, deleted, author, reason, source, hash"
+
+// scanVersionInfo reads version metadata selected with versionInfoColumns
+func scanVersionInfo(rows *sql.Rows) (entity.VersionInfo, error) {
+	var v entity.VersionInfo
+	var restoredFrom sql.NullInt64
+	if err := rows.Scan(&v.Version, &v.CreatedAt, &v.EffectiveFrom, &restoredFrom, &v.Deleted, &v.Author, &v.Reason, &v.Source, &v.Hash); err != nil {
+		return entity.VersionInfo{}, fmt.Errorf("failed to scan version: %w", err)
+	}
+	v.RestoredFrom = int(restoredFrom.Int64)
+	return v, nil
+}
+
 // CreateRecord inserts a new record and creates its first version
 func (s *SQLiteVersionedRecordService) CreateRecord(ctx context.Context, record entity.Record) error {
 	id := record.ID
@@ -149,9 +573,22 @@ func (s *SQLiteVersionedRecordService) CreateRecord(ctx context.Context, record
 		return ErrRecordIDInvalid
 	}
 
-	// Check if record already exists
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return ErrEffectiveTimeInFuture
+	}
+
+	// Check if record already exists; a deleted one is created again
 	var exists bool
-	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM records WHERE id = ?)", id).Scan(&exists)
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM records WHERE id = ? AND deleted_at IS NULL)", id).Scan(&exists)
 	if err != nil {
 		return fmt.Errorf("failed to check record existence: %w", err)
 	}
@@ -159,44 +596,99 @@ func (s *SQLiteVersionedRecordService) CreateRecord(ctx context.Context, record
 		return ErrRecordAlreadyExists
 	}
 
-	// Serialize data to JSON
-	dataJSON, err := json.Marshal(record.Data)
+	if err := createInTx(ctx, tx, record, now, effective); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// createInTx records the creation of a record that does not exist yet, or
+// was deleted, together with its first version since
+func createInTx(ctx context.Context, tx *sql.Tx, record entity.Record, now, effective time.Time) error {
+	if err := checkEffective(ctx, tx, record.ID, effective); err != nil {
+		return err
+	}
+
+	err := appendEvent(ctx, tx, recordEvent{
+		RecordID:       record.ID,
+		Type:           eventCreated,
+		Data:           record.Data,
+		CreatedAt:      now,
+		ChangeMetadata: changeMetadata(ctx),
+	})
 	if err != nil {
-		return fmt.Errorf("failed to marshal record data: %w", err)
+		return err
 	}
 
-	now := time.Now()
+	// Insert first version
+	_, err = insertVersion(ctx, tx, entity.RecordVersion{
+		RecordID:       record.ID,
+		Data:           record.Data,
+		CreatedAt:      now,
+		EffectiveFrom:  effective,
+		ChangeMetadata: changeMetadata(ctx),
+	})
+	return err
+}
 
-	// Start transaction
-	tx, err := s.db.BeginTx(ctx, nil)
+// insertVersion appends a version of a record with the next version number,
+// sealed to the version before it, and returns that number. The version's ID,
+// Version and Hash fields are ignored.
+func insertVersion(ctx context.Context, tx *sql.Tx, v entity.RecordVersion) (int, error) {
+	// Get next version number and the hash of the version before it
+	var nextVersion int
+	var prevHash string
+	err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) + 1,
+			COALESCE((SELECT hash FROM record_versions WHERE record_id = ? ORDER BY version DESC LIMIT 1), '')
+		FROM record_versions WHERE record_id = ?`,
+		v.RecordID, v.RecordID,
+	).Scan(&nextVersion, &prevHash)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return 0, fmt.Errorf("failed to get next version: %w", err)
 	}
-	defer tx.Rollback()
 
-	// Insert record
-	_, err = tx.ExecContext(ctx,
-		"INSERT INTO records (id, data, created_at, updated_at) VALUES (?, ?, ?, ?)",
-		id, string(dataJSON), now, now,
-	)
+	v.Version = nextVersion
+	hash, err := versionHash(v, prevHash)
 	if err != nil {
-		return fmt.Errorf("failed to insert record: %w", err)
+		return 0, err
 	}
 
-	// Insert first version
+	stored, keyframe, err := encodeVersion(ctx, tx, v.RecordID, nextVersion, v.Data)
+	if err != nil {
+		return 0, err
+	}
+
+	var restoredFrom interface{}
+	if v.RestoredFrom > 0 {
+		restoredFrom = v.RestoredFrom
+	}
+
+	// Insert new version
 	_, err = tx.ExecContext(ctx,
-		"INSERT INTO record_versions (record_id, version, data, created_at) VALUES (?, ?, ?, ?)",
-		id, 1, string(dataJSON), now,
+		"INSERT INTO record_versions (record_id, version, data, keyframe, created_at, effective_from, restored_from, deleted, author, reason, source, hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		v.RecordID, nextVersion, stored, keyframe, v.CreatedAt, v.EffectiveFrom, restoredFrom, v.Deleted, v.Author, v.Reason, v.Source, hash,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to insert record version: %w", err)
+		return 0, fmt.Errorf("failed to insert record version: %w", err)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	// Anchor the chain on the record, so versions removed from its end or
+	// stripped of their hash are noticed
+	_, err = tx.ExecContext(ctx,
+		"UPDATE records SET head_version = ?, head_hash = ?, sealed_from = COALESCE(sealed_from, ?) WHERE id = ?",
+		nextVersion, hash, nextVersion, v.RecordID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to anchor record version: %w", err)
 	}
 
-	return nil
+	return nextVersion, nil
 }
 
 // UpdateRecord updates a record and creates a new version
@@ -205,98 +697,153 @@ func (s *SQLiteVersionedRecordService) UpdateRecord(ctx context.Context, id int,
 		return entity.Record{}, ErrRecordIDInvalid
 	}
 
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Record{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return entity.Record{}, ErrEffectiveTimeInFuture
+	}
+
+	record, err := updateInTx(ctx, tx, id, updates, now, effective)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return entity.Record{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return record, nil
+}
+
+// updateInTx applies updates to the current state of a record, read within the
+// same transaction so that concurrent writers cannot interleave, and appends
+// the result as a new version
+func updateInTx(ctx context.Context, tx *sql.Tx, id int, updates map[string]*string, now, effective time.Time) (entity.Record, error) {
 	// Get current record
-	record, err := s.GetRecord(ctx, id)
+	current, err := readCurrent(ctx, tx, id)
 	if err != nil {
 		return entity.Record{}, err
 	}
 
+	if err := checkEffective(ctx, tx, id, effective); err != nil {
+		return entity.Record{}, err
+	}
+
 	// Apply updates
-	for key, value := range updates {
-		if value == nil {
-			delete(record.Data, key)
-		} else {
-			record.Data[key] = *value
-		}
+	data := applyUpdates(current, updates)
+
+	err = appendEvent(ctx, tx, recordEvent{
+		RecordID:       id,
+		Type:           eventUpdated,
+		Data:           data,
+		CreatedAt:      now,
+		ChangeMetadata: changeMetadata(ctx),
+	})
+	if err != nil {
+		return entity.Record{}, err
 	}
 
-	// Serialize updated data to JSON
-	dataJSON, err := json.Marshal(record.Data)
+	_, err = insertVersion(ctx, tx, entity.RecordVersion{
+		RecordID:       id,
+		Data:           data,
+		CreatedAt:      now,
+		EffectiveFrom:  effective,
+		ChangeMetadata: changeMetadata(ctx),
+	})
 	if err != nil {
-		return entity.Record{}, fmt.Errorf("failed to marshal record data: %w", err)
+		return entity.Record{}, err
 	}
 
-	now := time.Now()
+	return entity.Record{
+		ID:   id,
+		Data: data,
+	}, nil
+}
+
+// checkEffective returns ErrEffectiveTimeConflict if a new version of a record
+// effective at effective would precede its latest effective version
+func checkEffective(ctx context.Context, tx *sql.Tx, id int, effective time.Time) error {
+	var latestEffective time.Time
+	err := tx.QueryRowContext(ctx,
+		"SELECT effective_from FROM record_versions WHERE record_id = ? ORDER BY julianday(effective_from) DESC LIMIT 1",
+		id,
+	).Scan(&latestEffective)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get latest effective time: %w", err)
+	}
+	if effective.Before(latestEffective) {
+		return ErrEffectiveTimeConflict
+	}
+	return nil
+}
+
+// UpsertRecord applies updates to a record, creating it if it does not exist
+// or was deleted, in a single transaction
+func (s *SQLiteVersionedRecordService) UpsertRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	record, _, err := s.UpsertRecordWithVersion(ctx, id, updates)
+	return record, err
+}
+
+// UpsertRecordWithVersion creates or updates a record atomically and returns
+// the number of the version it appended
+func (s *SQLiteVersionedRecordService) UpsertRecordWithVersion(ctx context.Context, id int, updates map[string]*string) (entity.Record, int, error) {
+	if id <= 0 {
+		return entity.Record{}, 0, ErrRecordIDInvalid
+	}
 
 	// Start transaction
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return entity.Record{}, fmt.Errorf("failed to begin transaction: %w", err)
+		return entity.Record{}, 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	// Get next version number
-	var nextVersion int
-	err = tx.QueryRowContext(ctx,
-		"SELECT COALESCE(MAX(version), 0) + 1 FROM record_versions WHERE record_id = ?",
-		id,
-	).Scan(&nextVersion)
-	if err != nil {
-		return entity.Record{}, fmt.Errorf("failed to get next version: %w", err)
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return entity.Record{}, 0, ErrEffectiveTimeInFuture
 	}
 
-	// Update record in database
-	_, err = tx.ExecContext(ctx,
-		"UPDATE records SET data = ?, updated_at = ? WHERE id = ?",
-		string(dataJSON), now, id,
-	)
+	record, err := updateInTx(ctx, tx, id, updates, now, effective)
+	if errors.Is(err, ErrRecordDoesNotExist) || errors.Is(err, ErrRecordDeleted) {
+		// Create new record - exclude null values
+		record = entity.Record{
+			ID:   id,
+			Data: applyUpdates(nil, updates),
+		}
+		err = createInTx(ctx, tx, record, now, effective)
+	}
 	if err != nil {
-		return entity.Record{}, fmt.Errorf("failed to update record: %w", err)
+		return entity.Record{}, 0, err
 	}
 
-	// Insert new version
-	_, err = tx.ExecContext(ctx,
-		"INSERT INTO record_versions (record_id, version, data, created_at) VALUES (?, ?, ?, ?)",
-		id, nextVersion, string(dataJSON), now,
-	)
+	version, err := latestVersion(ctx, tx, id)
 	if err != nil {
-		return entity.Record{}, fmt.Errorf("failed to insert record version: %w", err)
+		return entity.Record{}, 0, err
 	}
 
 	if err := tx.Commit(); err != nil {
-		return entity.Record{}, fmt.Errorf("failed to commit transaction: %w", err)
+		return entity.Record{}, 0, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return record, nil
+	return record, version, nil
 }
 
 // CreateOrUpdateRecord creates a new record or updates an existing one, preserving history
 func (s *SQLiteVersionedRecordService) CreateOrUpdateRecord(ctx context.Context, record entity.Record) (entity.Record, error) {
-	id := record.ID
-	if id <= 0 {
-		return entity.Record{}, ErrRecordIDInvalid
-	}
-
-	// Check if record exists
-	_, err := s.GetRecord(ctx, id)
-	if err != nil && !errors.Is(err, ErrRecordDoesNotExist) {
-		return entity.Record{}, err
-	}
-
-	if errors.Is(err, ErrRecordDoesNotExist) {
-		// Create new record
-		if err := s.CreateRecord(ctx, record); err != nil {
-			return entity.Record{}, err
-		}
-		return record, nil
-	}
-
-	// Update existing record - merge updates
+	// Merge the record's values into any existing record
 	updates := make(map[string]*string)
 	for key, value := range record.Data {
 		val := value
 		updates[key] = &val
 	}
 
-	return s.UpdateRecord(ctx, id, updates)
+	return s.UpsertRecord(ctx, record.ID, updates)
 }
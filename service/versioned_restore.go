@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// RestoreVersion appends a new version holding exactly the data of an earlier
+// version and makes it the record's current state. Keys added after that
+// version are removed, and history is never rewritten. A deleted record must
+// be undeleted first, and a tombstone cannot be restored. The new version is
+// effective from the effective time carried by ctx, like any other write.
+func (s *SQLiteVersionedRecordService) RestoreVersion(ctx context.Context, id int, version int) (entity.Record, error) {
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+	if version <= 0 {
+		return entity.Record{}, ErrInvalidVersion
+	}
+
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Record{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	effective := effectiveFrom(ctx, now)
+	if effective.After(now) {
+		return entity.Record{}, ErrEffectiveTimeInFuture
+	}
+
+	if _, err := readCurrent(ctx, tx, id); err != nil {
+		return entity.Record{}, err
+	}
+
+	data, err := readVersion(ctx, tx, id, version)
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	var tombstone bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT deleted FROM record_versions WHERE record_id = ? AND version = ?",
+		id, version,
+	).Scan(&tombstone)
+	if err != nil {
+		return entity.Record{}, fmt.Errorf("failed to query record version: %w", err)
+	}
+	if tombstone {
+		return entity.Record{}, ErrVersionDeleted
+	}
+
+	if err := checkEffective(ctx, tx, id, effective); err != nil {
+		return entity.Record{}, err
+	}
+
+	err = appendEvent(ctx, tx, recordEvent{
+		RecordID:       id,
+		Type:           eventRestored,
+		Data:           data,
+		CreatedAt:      now,
+		ChangeMetadata: changeMetadata(ctx),
+	})
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	_, err = insertVersion(ctx, tx, entity.RecordVersion{
+		RecordID:       id,
+		Data:           data,
+		CreatedAt:      now,
+		EffectiveFrom:  effective,
+		RestoredFrom:   version,
+		ChangeMetadata: changeMetadata(ctx),
+	})
+	if err != nil {
+		return entity.Record{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return entity.Record{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return entity.Record{
+		ID:   id,
+		Data: data,
+	}, nil
+}
@@ -0,0 +1,378 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+var (
+	ErrRetentionInvalid = errors.New("retention limits must not be negative")
+	ErrVersionCompacted = errors.New("versions current at that time were removed by retention")
+)
+
+// RetentionService bounds the growth of version history. It is implemented by
+// backends that can prune versions.
+type RetentionService interface {
+	// GetRetention returns the retention configuration of a record
+	GetRetention(ctx context.Context, id int) (entity.Retention, error)
+
+	// SetRetention overrides the global policy for a record, or makes it
+	// follow the global policy again if policy is nil
+	SetRetention(ctx context.Context, id int, policy *entity.RetentionPolicy) error
+
+	// SetLegalHold places or releases a legal hold on a record. Nothing is
+	// pruned from a record's history while it is on hold.
+	SetLegalHold(ctx context.Context, id int, hold bool) error
+
+	// Compact prunes the versions of every record that its policy, or global
+	// if it has none, no longer keeps, and returns how many were removed.
+	// Only the oldest versions of a record are pruned, up to its first tagged
+	// version or the first version a remaining version was restored from,
+	// and a marker listed by ListVersions is left in their place.
+	Compact(ctx context.Context, global entity.RetentionPolicy) (int, error)
+}
+
+// GetRetention returns the retention configuration of a record
+func (s *SQLiteVersionedRecordService) GetRetention(ctx context.Context, id int) (entity.Retention, error) {
+	if id <= 0 {
+		return entity.Retention{}, ErrRecordIDInvalid
+	}
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM records WHERE id = ?)", id).Scan(&exists)
+	if err != nil {
+		return entity.Retention{}, fmt.Errorf("failed to check record existence: %w", err)
+	}
+	if !exists {
+		return entity.Retention{}, ErrRecordDoesNotExist
+	}
+
+	policy, hold, err := loadRetention(ctx, s.db, id)
+	if err != nil {
+		return entity.Retention{}, err
+	}
+
+	return entity.Retention{
+		ID:        id,
+		Policy:    policy,
+		LegalHold: hold,
+	}, nil
+}
+
+// loadRetention returns the policy override and legal hold of a record
+func loadRetention(ctx context.Context, q queryer, id int) (*entity.RetentionPolicy, bool, error) {
+	var keepLast, keepDays sql.NullInt64
+	var hold bool
+	err := q.QueryRowContext(ctx,
+		"SELECT keep_last, keep_days, legal_hold FROM record_retention WHERE record_id = ?",
+		id,
+	).Scan(&keepLast, &keepDays, &hold)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query retention: %w", err)
+	}
+
+	if !keepLast.Valid && !keepDays.Valid {
+		return nil, hold, nil
+	}
+	return &entity.RetentionPolicy{
+		KeepLast: int(keepLast.Int64),
+		KeepDays: int(keepDays.Int64),
+	}, hold, nil
+}
+
+// SetRetention overrides the global retention policy for a record
+func (s *SQLiteVersionedRecordService) SetRetention(ctx context.Context, id int, policy *entity.RetentionPolicy) error {
+	var keepLast, keepDays interface{}
+	if policy != nil {
+		if policy.KeepLast < 0 || policy.KeepDays < 0 {
+			return ErrRetentionInvalid
+		}
+		keepLast, keepDays = policy.KeepLast, policy.KeepDays
+	}
+
+	return s.saveRetention(ctx, id,
+		`INSERT INTO record_retention (record_id, keep_last, keep_days, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (record_id) DO UPDATE SET keep_last = excluded.keep_last, keep_days = excluded.keep_days, updated_at = excluded.updated_at`,
+		keepLast, keepDays,
+	)
+}
+
+// SetLegalHold places or releases a legal hold on a record
+func (s *SQLiteVersionedRecordService) SetLegalHold(ctx context.Context, id int, hold bool) error {
+	return s.saveRetention(ctx, id,
+		`INSERT INTO record_retention (record_id, legal_hold, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (record_id) DO UPDATE SET legal_hold = excluded.legal_hold, updated_at = excluded.updated_at`,
+		hold,
+	)
+}
+
+// saveRetention runs an upsert of a record's retention row. args are the
+// values following the record ID; the update time is appended.
+func (s *SQLiteVersionedRecordService) saveRetention(ctx context.Context, id int, query string, args ...interface{}) error {
+	if id <= 0 {
+		return ErrRecordIDInvalid
+	}
+
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM records WHERE id = ?)", id).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check record existence: %w", err)
+	}
+	if !exists {
+		return ErrRecordDoesNotExist
+	}
+
+	args = append(append([]interface{}{id}, args...), time.Now())
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to save retention: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Compact prunes the versions no longer kept by retention from every record
+func (s *SQLiteVersionedRecordService) Compact(ctx context.Context, global entity.RetentionPolicy) (int, error) {
+	if global.KeepLast < 0 || global.KeepDays < 0 {
+		return 0, ErrRetentionInvalid
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM records ORDER BY id ASC")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query records: %w", err)
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan record: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating records: %w", err)
+	}
+
+	// Each record is compacted in its own transaction so writers are only
+	// held up for one record at a time
+	pruned := 0
+	for _, id := range ids {
+		n, err := s.compactRecord(ctx, id, global)
+		if err != nil {
+			return pruned, fmt.Errorf("failed to compact record %d: %w", id, err)
+		}
+		pruned += n
+	}
+
+	return pruned, nil
+}
+
+// compactRecord prunes the versions of a record no longer kept by retention
+// and returns how many were removed
+func (s *SQLiteVersionedRecordService) compactRecord(ctx context.Context, id int, global entity.RetentionPolicy) (int, error) {
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	policy, hold, err := loadRetention(ctx, tx, id)
+	if err != nil {
+		return 0, err
+	}
+	if hold {
+		return 0, nil
+	}
+	if policy == nil {
+		policy = &global
+	}
+	if policy.KeepLast == 0 && policy.KeepDays == 0 {
+		return 0, nil
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT version, keyframe, created_at, effective_from, restored_from, hash FROM record_versions WHERE record_id = ? ORDER BY version ASC",
+		id,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query versions: %w", err)
+	}
+
+	type storedVersion struct {
+		version       int
+		keyframe      bool
+		createdAt     time.Time
+		effectiveFrom time.Time
+		restoredFrom  sql.NullInt64
+		hash          string
+	}
+	var versions []storedVersion
+	for rows.Next() {
+		var v storedVersion
+		if err := rows.Scan(&v.version, &v.keyframe, &v.createdAt, &v.effectiveFrom, &v.restoredFrom, &v.hash); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating versions: %w", err)
+	}
+
+	tags, err := loadTags(ctx, tx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	// Count the oldest versions that are neither kept by the policy nor
+	// tagged. A version is still current until the next one is recorded, so
+	// its age for KeepDays is measured from then.
+	cutoff := now.AddDate(0, 0, -policy.KeepDays)
+	prune := 0
+	for i := 0; i < len(versions)-1; i++ {
+		keptByCount := policy.KeepLast > 0 && len(versions)-i <= policy.KeepLast
+		keptByAge := policy.KeepDays > 0 && versions[i+1].createdAt.After(cutoff)
+		if keptByCount || keptByAge || len(tags[versions[i].version]) > 0 {
+			break
+		}
+		prune++
+	}
+
+	// A version that a remaining version was restored from is kept as well,
+	// so restored_from never points into pruned history. It is sealed into
+	// the hash of the restored version, so it cannot be cleared instead.
+	for changed := true; changed; {
+		changed = false
+		for _, v := range versions[prune:] {
+			for prune > 0 && v.restoredFrom.Valid && int(v.restoredFrom.Int64) <= versions[prune-1].version {
+				prune--
+				changed = true
+			}
+		}
+	}
+	if prune == 0 {
+		return 0, nil
+	}
+
+	// The oldest remaining version can no longer be stored as a delta
+	first, last := versions[prune], versions[prune-1]
+	if !first.keyframe {
+		data, err := readVersion(ctx, tx, id, first.version)
+		if err != nil {
+			return 0, err
+		}
+		dataJSON, err := json.Marshal(data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal record data: %w", err)
+		}
+		_, err = tx.ExecContext(ctx,
+			"UPDATE record_versions SET data = ?, keyframe = 1 WHERE record_id = ? AND version = ?",
+			string(dataJSON), id, first.version,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to store keyframe: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM record_versions WHERE record_id = ? AND version <= ?",
+		id, last.version,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune versions: %w", err)
+	}
+
+	// The event log would otherwise still hold the pruned states
+	if err := compactEvents(ctx, tx, id, first.createdAt); err != nil {
+		return 0, err
+	}
+
+	// Extend the marker left by earlier compactions, if any. It keeps the
+	// hash of the last version removed, which the oldest remaining version
+	// is sealed to.
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO record_compactions (record_id, from_version, to_version, last_created_at, last_effective_from, last_hash, compacted_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (record_id) DO UPDATE SET to_version = excluded.to_version, last_created_at = excluded.last_created_at,
+			last_effective_from = excluded.last_effective_from, last_hash = excluded.last_hash, compacted_at = excluded.compacted_at`,
+		id, versions[0].version, last.version, last.createdAt, last.effectiveFrom, last.hash, now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record compaction: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return prune, nil
+}
+
+// missingAt returns the error for a read of a record as known at knownAt that
+// found no version: ErrVersionCompacted if the record already existed then
+// and retention removed some of its oldest versions, which could have
+// answered it, or ErrRecordDoesNotExist otherwise
+func missingAt(ctx context.Context, q queryer, id int, knownAt time.Time) error {
+	var createdAt time.Time
+	var compacted bool
+	err := q.QueryRowContext(ctx,
+		"SELECT created_at, EXISTS(SELECT 1 FROM record_compactions WHERE record_id = records.id) FROM records WHERE id = ?",
+		id,
+	).Scan(&createdAt, &compacted)
+	if err == sql.ErrNoRows {
+		return ErrRecordDoesNotExist
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query record: %w", err)
+	}
+	if compacted && !knownAt.Before(createdAt) {
+		return ErrVersionCompacted
+	}
+	return ErrRecordDoesNotExist
+}
+
+// loadCompaction returns the marker left in place of a record's pruned
+// versions, or nil if none were pruned
+func loadCompaction(ctx context.Context, q queryer, id int) (*entity.VersionInfo, error) {
+	var marker entity.VersionInfo
+	compaction := entity.Compaction{}
+	err := q.QueryRowContext(ctx,
+		"SELECT from_version, to_version, last_created_at, last_effective_from, last_hash, compacted_at FROM record_compactions WHERE record_id = ?",
+		id,
+	).Scan(&compaction.FromVersion, &compaction.ToVersion, &marker.CreatedAt, &marker.EffectiveFrom, &marker.Hash, &compaction.CompactedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compaction: %w", err)
+	}
+
+	marker.Version = compaction.ToVersion
+	marker.Compacted = &compaction
+	return &marker, nil
+}
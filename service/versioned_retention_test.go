@@ -0,0 +1,93 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+	"github.com/rainbowmga/timetravel/service"
+)
+
+// TestAsOfCompacted reads a record at times whose versions were pruned by
+// retention
+func TestAsOfCompacted(t *testing.T) {
+	ctx := context.Background()
+	s := service.NewSQLiteVersionedRecordService(newTestDB(t))
+
+	before := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	var during time.Time
+	for i, value := range []string{"1", "2", "3"} {
+		value := value
+		if _, err := s.UpsertRecord(ctx, 1, map[string]*string{"a": &value}); err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+		if i == 0 {
+			time.Sleep(5 * time.Millisecond)
+			during = time.Now()
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	pruned, err := s.Compact(ctx, entity.RetentionPolicy{KeepLast: 1})
+	if err != nil || pruned != 2 {
+		t.Fatalf("Compact = %d, %v; want 2 versions pruned", pruned, err)
+	}
+
+	if _, err := s.GetRecordAsOf(ctx, 1, during); !errors.Is(err, service.ErrVersionCompacted) {
+		t.Errorf("GetRecordAsOf(pruned) = %v, want ErrVersionCompacted", err)
+	}
+	if _, err := s.GetRecordAt(ctx, 1, during, during); !errors.Is(err, service.ErrVersionCompacted) {
+		t.Errorf("GetRecordAt(pruned) = %v, want ErrVersionCompacted", err)
+	}
+	if _, err := s.GetRecordAsOf(ctx, 1, before); !errors.Is(err, service.ErrRecordDoesNotExist) {
+		t.Errorf("GetRecordAsOf(before creation) = %v, want ErrRecordDoesNotExist", err)
+	}
+	got, err := s.GetRecordAsOf(ctx, 1, time.Now())
+	if err != nil || got.Data["a"] != "3" {
+		t.Errorf("GetRecordAsOf(now) = %v, %v; want a=3", got.Data, err)
+	}
+}
+
+// TestCompactKeepsRestoredFrom checks that compaction keeps the versions that
+// remaining versions were restored from
+func TestCompactKeepsRestoredFrom(t *testing.T) {
+	ctx := context.Background()
+	s := service.NewSQLiteVersionedRecordService(newTestDB(t))
+
+	for _, value := range []string{"1", "2", "3", "4"} {
+		value := value
+		if _, err := s.UpsertRecord(ctx, 1, map[string]*string{"a": &value}); err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+	}
+	// Version 5 restores version 2
+	if _, err := s.RestoreVersion(ctx, 1, 2); err != nil {
+		t.Fatalf("RestoreVersion: %v", err)
+	}
+
+	pruned, err := s.Compact(ctx, entity.RetentionPolicy{KeepLast: 1})
+	if err != nil || pruned != 1 {
+		t.Fatalf("Compact = %d, %v; want only version 1 pruned", pruned, err)
+	}
+
+	versions, err := s.ListVersions(ctx, 1)
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+	for _, v := range versions {
+		if v.RestoredFrom == 0 {
+			continue
+		}
+		if _, err := s.GetRecordVersion(ctx, 1, v.RestoredFrom); err != nil {
+			t.Errorf("version %d was restored from version %d, which cannot be read: %v", v.Version, v.RestoredFrom, err)
+		}
+	}
+
+	verification, err := s.Verify(ctx, 1)
+	if err != nil || !verification.Valid {
+		t.Errorf("Verify = %+v, %v; want valid", verification, err)
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// errStopScan is returned by the function given to scanAsOf to end the scan
+// early without failing it
+var errStopScan = errors.New("stop scan")
+
+// Snapshot streams every record as it was at time t, leaving out those deleted
+// at the time. It fails with ErrVersionCompacted once it reaches a record
+// whose versions current at t were pruned by retention.
+func (s *SQLiteVersionedRecordService) Snapshot(ctx context.Context, t time.Time, fn func(entity.Record) error) error {
+	return s.scanAsOf(ctx, 0, t, func(v entity.RecordVersion) error {
+		if v.Deleted {
+			return nil
+		}
+		return fn(entity.Record{ID: v.RecordID, Data: v.Data})
+	})
+}
+
+// scanAsOf calls fn, in record order, with the version of every record with an
+// ID above after that was current at time t, tombstones included, as resolved
+// by versionAsOf. Versions are read in record and version order, so only one
+// record's versions are held in memory at a time. If fn returns errStopScan
+// the scan ends without error. A record that existed at t but no longer has
+// a version from then fails the scan with ErrVersionCompacted, rather than
+// being left out as if it had not existed.
+func (s *SQLiteVersionedRecordService) scanAsOf(ctx context.Context, after int, t time.Time, fn func(entity.RecordVersion) error) error {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+versionColumns+" FROM record_versions WHERE record_id > ? ORDER BY record_id ASC, version ASC",
+		after,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query versions: %w", err)
+	}
+	defer rows.Close()
+
+	// versions are those of the record being read
+	var decoder versionDecoder
+	var versions []entity.RecordVersion
+	emit := func() error {
+		if v, ok := versionAsOf(versions, t); ok {
+			return fn(v)
+		}
+		if err := missingAt(ctx, s.db, versions[0].RecordID, t); err == ErrVersionCompacted {
+			return fmt.Errorf("record %d: %w", versions[0].RecordID, err)
+		}
+		return nil
+	}
+
+	for rows.Next() {
+		v, err := scanVersion(rows, &decoder)
+		if err != nil {
+			return err
+		}
+
+		if len(versions) > 0 && versions[0].RecordID != v.RecordID {
+			if err := emit(); err == errStopScan {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			versions = versions[:0]
+		}
+		versions = append(versions, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating versions: %w", err)
+	}
+
+	if len(versions) > 0 {
+		if err := emit(); err != errStopScan {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+var (
+	ErrTagInvalid       = errors.New("tag must be 1-64 letters, digits, '.', '_' or '-' and start with a letter or digit")
+	ErrTagDoesNotExist  = errors.New("tag does not exist")
+	ErrTagAlreadyExists = errors.New("tag already exists on a different version")
+	validTag            = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+)
+
+// TagVersion names a version of a record
+func (s *SQLiteVersionedRecordService) TagVersion(ctx context.Context, id int, version int, tag string) error {
+	return s.setTag(ctx, id, version, tag, false)
+}
+
+// MoveTag points an existing tag at a different version
+func (s *SQLiteVersionedRecordService) MoveTag(ctx context.Context, id int, version int, tag string) error {
+	return s.setTag(ctx, id, version, tag, true)
+}
+
+// setTag creates a tag, or moves an existing one if move is set
+func (s *SQLiteVersionedRecordService) setTag(ctx context.Context, id int, version int, tag string, move bool) error {
+	if id <= 0 {
+		return ErrRecordIDInvalid
+	}
+	if version <= 0 {
+		return ErrInvalidVersion
+	}
+	if !validTag.MatchString(tag) {
+		return ErrTagInvalid
+	}
+
+	now := time.Now()
+
+	// Start transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM record_versions WHERE record_id = ? AND version = ?)",
+		id, version,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check version existence: %w", err)
+	}
+	if !exists {
+		return ErrVersionDoesNotExist
+	}
+
+	var current int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COALESCE((SELECT version FROM record_tags WHERE record_id = ? AND tag = ?), 0)",
+		id, tag,
+	).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to query tag: %w", err)
+	}
+
+	switch {
+	case current == version:
+		return nil
+	case current == 0 && move:
+		return ErrTagDoesNotExist
+	case current != 0 && !move:
+		return ErrTagAlreadyExists
+	case current == 0:
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO record_tags (record_id, tag, version, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+			id, tag, version, now, now,
+		)
+	default:
+		_, err = tx.ExecContext(ctx,
+			"UPDATE record_tags SET version = ?, updated_at = ? WHERE record_id = ? AND tag = ?",
+			version, now, id, tag,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save tag: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetRecordByTag retrieves a record at the version a tag points at
+func (s *SQLiteVersionedRecordService) GetRecordByTag(ctx context.Context, id int, tag string) (entity.Record, error) {
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+
+	var version int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COALESCE((SELECT version FROM record_tags WHERE record_id = ? AND tag = ?), 0)",
+		id, tag,
+	).Scan(&version)
+	if err != nil {
+		return entity.Record{}, fmt.Errorf("failed to query tag: %w", err)
+	}
+	if version == 0 {
+		return entity.Record{}, ErrTagDoesNotExist
+	}
+
+	return s.GetRecordVersion(ctx, id, version)
+}
+
+// loadTags returns the tags of a record grouped by the version they point at
+func loadTags(ctx context.Context, q queryer, id int) (map[int][]string, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT tag, version FROM record_tags WHERE record_id = ? ORDER BY tag ASC",
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := map[int][]string{}
+	for rows.Next() {
+		var tag string
+		var version int
+		if err := rows.Scan(&tag, &version); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags[version] = append(tags[version], tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tags: %w", err)
+	}
+
+	return tags, nil
+}
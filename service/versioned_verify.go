@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+
+	"github.com/rainbowmga/timetravel/entity"
+)
+
+// IntegrityService proves that version history was not edited where it is
+// stored. It is implemented by backends that seal versions in a hash chain.
+type IntegrityService interface {
+	// Verify checks the hash chain of a record's versions and reports the
+	// first broken link, if any
+	Verify(ctx context.Context, id int) (entity.Verification, error)
+
+	// VerifyAll checks the hash chain of every record with versions or with a
+	// head kept on the record, calling fn with each result in ID order. If fn
+	// returns an error verification stops and returns it.
+	VerifyAll(ctx context.Context, fn func(entity.Verification) error) error
+}
+
+// Verify checks the hash chain of a record's versions
+func (s *SQLiteVersionedRecordService) Verify(ctx context.Context, id int) (entity.Verification, error) {
+	if id <= 0 {
+		return entity.Verification{}, ErrRecordIDInvalid
+	}
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM records WHERE id = ?)", id).Scan(&exists)
+	if err != nil {
+		return entity.Verification{}, fmt.Errorf("failed to check record existence: %w", err)
+	}
+	if !exists {
+		return entity.Verification{}, ErrRecordDoesNotExist
+	}
+
+	result := entity.Verification{ID: id, Valid: true}
+	err = s.verify(ctx, id, func(v entity.Verification) error {
+		result = v
+		return nil
+	})
+	return result, err
+}
+
+// VerifyAll checks the hash chain of every record with versions or an anchor
+func (s *SQLiteVersionedRecordService) VerifyAll(ctx context.Context, fn func(entity.Verification) error) error {
+	return s.verify(ctx, 0, fn)
+}
+
+// verify streams the versions of record id, or of every record if id is 0,
+// through a chain verifier per record, calling fn with the result for each
+// record. Anchored records with no versions left are reported as well.
+func (s *SQLiteVersionedRecordService) verify(ctx context.Context, id int, fn func(entity.Verification) error) error {
+	where, args := "", []interface{}(nil)
+	if id > 0 {
+		where, args = "WHERE record_id = ?", []interface{}{id}
+	}
+
+	// The chain of a compacted record continues from its marker
+	type start struct {
+		version int
+		hash    string
+	}
+	starts := map[int]start{}
+	rows, err := s.db.QueryContext(ctx, "SELECT record_id, to_version, last_hash FROM record_compactions")
+	if err != nil {
+		return fmt.Errorf("failed to query compactions: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		var marker start
+		if err := rows.Scan(&id, &marker.version, &marker.hash); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan compaction: %w", err)
+		}
+		starts[id] = start{version: marker.version + 1, hash: marker.hash}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating compactions: %w", err)
+	}
+
+	// The head of each chain is anchored on its record
+	anchorWhere := "WHERE head_version IS NOT NULL"
+	if id > 0 {
+		anchorWhere += " AND id = ?"
+	}
+	anchors := map[int]*chainAnchor{}
+	var anchored []int
+	rows, err = s.db.QueryContext(ctx,
+		"SELECT id, sealed_from, head_version, head_hash FROM records "+anchorWhere+" ORDER BY id ASC",
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query anchors: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		var sealedFrom sql.NullInt64
+		var headHash sql.NullString
+		anchor := &chainAnchor{}
+		if err := rows.Scan(&id, &sealedFrom, &anchor.headVersion, &headHash); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan anchor: %w", err)
+		}
+		// A chain anchored without a first sealed version must be sealed
+		// throughout
+		anchor.sealedFrom = int(sealedFrom.Int64)
+		anchor.headHash = headHash.String
+		anchors[id] = anchor
+		anchored = append(anchored, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating anchors: %w", err)
+	}
+
+	newChain := func(id int) *chainVerifier {
+		first, ok := starts[id]
+		if !ok {
+			first.version = 1
+		}
+		return newChainVerifier(id, first.version, first.hash, anchors[id])
+	}
+	// emitUnseen reports the anchored records before id whose versions are
+	// all gone
+	emitUnseen := func(id int) error {
+		for len(anchored) > 0 && anchored[0] < id {
+			if err := fn(newChain(anchored[0]).finish()); err != nil {
+				return err
+			}
+			anchored = anchored[1:]
+		}
+		if len(anchored) > 0 && anchored[0] == id {
+			anchored = anchored[1:]
+		}
+		return nil
+	}
+
+	rows, err = s.db.QueryContext(ctx,
+		"SELECT "+versionColumns+" FROM record_versions "+where+" ORDER BY record_id ASC, version ASC",
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query versions: %w", err)
+	}
+	defer rows.Close()
+
+	var decoder versionDecoder
+	var chain *chainVerifier
+	for rows.Next() {
+		v, stored, keyframe, err := scanStoredVersion(rows)
+		if err != nil {
+			return err
+		}
+
+		if chain != nil && chain.result.ID != v.RecordID {
+			if err := fn(chain.finish()); err != nil {
+				return err
+			}
+			chain = nil
+		}
+		if chain == nil {
+			if err := emitUnseen(v.RecordID); err != nil {
+				return err
+			}
+			chain = newChain(v.RecordID)
+		}
+
+		// Data that cannot be decoded was altered as well
+		if err := decoder.decode(&v, stored, keyframe); err != nil {
+			if chain.result.Valid {
+				chain.result.Versions++
+				chain.fail(v.Version, "data cannot be decoded")
+			}
+			continue
+		}
+		chain.check(v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating versions: %w", err)
+	}
+
+	if chain != nil {
+		if err := fn(chain.finish()); err != nil {
+			return err
+		}
+	}
+	return emitUnseen(math.MaxInt)
+}